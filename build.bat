@@ -4,6 +4,7 @@
 SET build_dir=_build_
 SET exe_dir=cmd
 SET server_dir=proxy
+SET tool_dir=pwhash
 SET settings_file=whitelist.txt
 
 MKDIR "%build_dir%"
@@ -14,5 +15,11 @@ go build
 MOVE "%server_dir%.exe" ".\..\..\%build_dir%\"
 CD ".\..\..\"
 
+:: Build the password hashing tool.
+CD "%exe_dir%\%tool_dir%"
+go build
+MOVE "%tool_dir%.exe" ".\..\..\%build_dir%\"
+CD ".\..\..\"
+
 :: Copy some additional files for the server.
 COPY "%exe_dir%\%server_dir%\%settings_file%" "%build_dir%\"
@@ -37,10 +37,22 @@ func main() {
 
 	err = srv.Start()
 	mustBeNoError(err)
-	fmt.Println("HTTP Server: " + srv.GetListenDsn())
+	if os.Geteuid() == 0 {
+		log.Println("Warning: the server is running as root, use '-runuser' parameter to drop privileges.")
+	}
+	for _, dsn := range srv.GetListenDsns() {
+		fmt.Println("HTTP Server: " + dsn)
+	}
+	if len(srv.GetSocksListenDsn()) > 0 {
+		fmt.Println("SOCKS Server: " + srv.GetSocksListenDsn())
+	}
+	if len(srv.GetParentProxy()) > 0 {
+		fmt.Println("Parent Proxy: " + srv.GetParentProxy())
+	}
 
 	serverMustBeStopped := srv.GetStopChannel()
 	waitForQuitSignalFromOS(serverMustBeStopped)
+	waitForReloadSignalFromOS(srv)
 	<-*serverMustBeStopped
 
 	log.Println("Stopping the server ...")
@@ -81,3 +93,23 @@ func waitForQuitSignalFromOS(serverMustBeStopped *chan bool) {
 		}
 	}()
 }
+
+func waitForReloadSignalFromOS(srv *server.Server) {
+	osSignals := make(chan os.Signal, 16)
+	signal.Notify(osSignals, syscall.SIGHUP)
+
+	go func() {
+		for sig := range osSignals {
+			log.Println("reload signal from OS has been received: ", sig)
+			err := srv.ReloadList()
+			if err != nil {
+				log.Println("list reload error, old list is kept: ", err)
+			}
+
+			err = srv.ReloadCertificate()
+			if err != nil {
+				log.Println("certificate reload error, old certificate is kept: ", err)
+			}
+		}
+	}()
+}
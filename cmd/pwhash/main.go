@@ -0,0 +1,41 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	auth "github.com/vault-thirteen/Forward-Proxy/pkg/server/Auth"
+)
+
+// This tool creates a line for the file of users of the proxy server. The
+// password is read from the standard input, so that it does not get into the
+// history of the command shell.
+func main() {
+	userNameFlag := flag.String("user", "", "User name")
+	iterationsFlag := flag.Int("iter", auth.HashIterationsDefault, "Number of hashing iterations")
+	flag.Parse()
+
+	if len(*userNameFlag) == 0 {
+		fmt.Println("Use '-h' command line argument to get help.")
+		os.Exit(1)
+	}
+
+	fmt.Fprint(os.Stderr, "Password: ")
+	password, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if (err != nil) && (len(password) == 0) {
+		log.Fatal(err)
+	}
+	password = strings.TrimRight(password, "\r\n")
+
+	var ph *auth.PasswordHash
+	ph, err = auth.NewPasswordHash(password, *iterationsFlag)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	fmt.Println(*userNameFlag + auth.UserFileSeparator + ph.String())
+}
@@ -5,6 +5,7 @@ go 1.25.9
 require (
 	github.com/rs/zerolog v1.35.1
 	github.com/vault-thirteen/auxie v0.36.3
+	golang.org/x/time v0.15.0
 )
 
 require (
@@ -14,5 +15,4 @@ require (
 	github.com/mattn/go-isatty v0.0.22 // indirect
 	github.com/rogpeppe/go-internal v1.14.1 // indirect
 	golang.org/x/sys v0.43.0 // indirect
-	golang.org/x/time v0.15.0 // indirect
 )
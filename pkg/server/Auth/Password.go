@@ -0,0 +1,130 @@
+package auth
+
+import (
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	ErrPasswordHashSyntax   = "syntax error in password hash"
+	ErrUnknownHashAlgorithm = "unknown password hash algorithm: %v"
+	ErrIterationCountTooLow = "iteration count is too low: %v"
+	ErrSaltIsTooShort       = "salt is too short"
+	ErrHashHasWrongSize     = "hash has wrong size"
+	ErrPasswordIsEmpty      = "password is empty"
+)
+
+const (
+	PasswordHashSeparator  = "$"
+	PasswordHashPartsCount = 4
+	HashAlgorithmPbkdf2    = "pbkdf2-sha256"
+	HashSize               = sha256.Size
+	HashSaltSize           = 16
+	HashSaltSizeMin        = 8
+	HashIterationsMin      = 1_000
+	HashIterationsDefault  = 100_000
+)
+
+// PasswordHash is a salted hash of a password. In text form it looks as
+// following:
+//
+//	pbkdf2-sha256$<iterations>$<salt>$<hash>
+//
+// where salt and hash are encoded with standard Base64 encoding without
+// padding.
+type PasswordHash struct {
+	Iterations int
+	Salt       []byte
+	Hash       []byte
+}
+
+// NewPasswordHash creates a hash of the password with a random salt.
+func NewPasswordHash(password string, iterations int) (ph *PasswordHash, err error) {
+	if len(password) == 0 {
+		return nil, errors.New(ErrPasswordIsEmpty)
+	}
+	if iterations < HashIterationsMin {
+		return nil, fmt.Errorf(ErrIterationCountTooLow, iterations)
+	}
+
+	ph = &PasswordHash{
+		Iterations: iterations,
+		Salt:       make([]byte, HashSaltSize),
+	}
+
+	_, err = rand.Read(ph.Salt)
+	if err != nil {
+		return nil, err
+	}
+
+	ph.Hash, err = pbkdf2.Key(sha256.New, password, ph.Salt, ph.Iterations, HashSize)
+	if err != nil {
+		return nil, err
+	}
+
+	return ph, nil
+}
+
+func ParsePasswordHash(s string) (ph *PasswordHash, err error) {
+	parts := strings.Split(s, PasswordHashSeparator)
+	if len(parts) != PasswordHashPartsCount {
+		return nil, errors.New(ErrPasswordHashSyntax)
+	}
+
+	if parts[0] != HashAlgorithmPbkdf2 {
+		return nil, fmt.Errorf(ErrUnknownHashAlgorithm, parts[0])
+	}
+
+	ph = new(PasswordHash)
+	ph.Iterations, err = strconv.Atoi(parts[1])
+	if err != nil {
+		return nil, err
+	}
+	if ph.Iterations < HashIterationsMin {
+		return nil, fmt.Errorf(ErrIterationCountTooLow, ph.Iterations)
+	}
+
+	ph.Salt, err = base64.RawStdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, err
+	}
+	if len(ph.Salt) < HashSaltSizeMin {
+		return nil, errors.New(ErrSaltIsTooShort)
+	}
+
+	ph.Hash, err = base64.RawStdEncoding.DecodeString(parts[3])
+	if err != nil {
+		return nil, err
+	}
+	if len(ph.Hash) != HashSize {
+		return nil, errors.New(ErrHashHasWrongSize)
+	}
+
+	return ph, nil
+}
+
+func (ph *PasswordHash) String() string {
+	return strings.Join([]string{
+		HashAlgorithmPbkdf2,
+		strconv.Itoa(ph.Iterations),
+		base64.RawStdEncoding.EncodeToString(ph.Salt),
+		base64.RawStdEncoding.EncodeToString(ph.Hash),
+	}, PasswordHashSeparator)
+}
+
+// Verify checks whether the password matches the hash.
+func (ph *PasswordHash) Verify(password string) (ok bool) {
+	hash, err := pbkdf2.Key(sha256.New, password, ph.Salt, ph.Iterations, HashSize)
+	if err != nil {
+		return false
+	}
+
+	return subtle.ConstantTimeCompare(hash, ph.Hash) == 1
+}
@@ -0,0 +1,76 @@
+package auth
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	ae "github.com/vault-thirteen/auxie/errors"
+)
+
+const (
+	ErrSubjectFileSyntax      = "subject file '%v', line %d: %w"
+	ErrDuplicateSubjectInList = "duplicate subject in list: %v"
+)
+
+const (
+	SubjectFileCommentPrefix = "#"
+)
+
+// SubjectList is a list of common names (CN) of subjects of client
+// certificates which are allowed to use the proxy server.
+type SubjectList struct {
+	commonNames map[string]bool
+}
+
+// NewSubjectListFromFile reads the list of subjects from a text file. Each
+// line of the file contains a single common name. Empty lines and lines
+// starting with '#' are ignored.
+func NewSubjectListFromFile(path string) (sl *SubjectList, err error) {
+	var f *os.File
+	f, err = os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		derr := f.Close()
+		if derr != nil {
+			err = ae.Combine(err, derr)
+		}
+	}()
+
+	sl = &SubjectList{
+		commonNames: make(map[string]bool),
+	}
+
+	sc := bufio.NewScanner(f)
+	var lineNumber int
+	var line string
+	for sc.Scan() {
+		lineNumber++
+
+		line = strings.TrimSpace(sc.Text())
+		if (len(line) == 0) || strings.HasPrefix(line, SubjectFileCommentPrefix) {
+			continue
+		}
+
+		if sl.commonNames[line] {
+			err = fmt.Errorf(ErrDuplicateSubjectInList, line)
+			return nil, fmt.Errorf(ErrSubjectFileSyntax, path, lineNumber, err)
+		}
+		sl.commonNames[line] = true
+	}
+
+	err = sc.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	return sl, nil
+}
+
+func (sl *SubjectList) Contains(commonName string) bool {
+	return sl.commonNames[commonName]
+}
@@ -0,0 +1,196 @@
+package auth
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	ae "github.com/vault-thirteen/auxie/errors"
+)
+
+const (
+	ErrUserFileSyntax    = "user file '%v', line %d: %w"
+	ErrUserNameIsEmpty   = "user name is empty"
+	ErrDuplicateUserName = "duplicate user name: %v"
+	ErrUserLineSyntax    = "syntax error, expected '<name>:<password hash>'"
+)
+
+const (
+	UserFileCommentPrefix = "#"
+	UserFileSeparator     = ":"
+)
+
+const (
+	// DigestKeySize is the size of the secret key of password digests.
+	DigestKeySize = 32
+)
+
+// UserDB is a local database of users of the proxy server.
+//
+// Verification of a salted password hash is intentionally slow. To avoid
+// spending this time on every proxied request, a fast digest of each password
+// which has once been verified successfully is remembered in memory. The
+// digest is an HMAC keyed with a random secret of the process, so that the
+// remembered digests are of no use outside the process.
+//
+// A failed authentication always takes the time of a slow verification, also
+// for unknown users, so that the time does not tell whether a user exists.
+type UserDB struct {
+	users map[string]*PasswordHash
+
+	// dummyHash is verified for unknown users.
+	dummyHash *PasswordHash
+
+	digestKey    []byte
+	verifiedLock sync.RWMutex
+	verified     map[string][sha256.Size]byte
+}
+
+// NewUserDBFromFile reads the database of users from a text file. Each line
+// of the file has the following format:
+//
+//	<name>:<password hash>
+//
+// Empty lines and lines starting with '#' are ignored. See the PasswordHash
+// type for the format of a password hash.
+func NewUserDBFromFile(path string) (db *UserDB, err error) {
+	var f *os.File
+	f, err = os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		derr := f.Close()
+		if derr != nil {
+			err = ae.Combine(err, derr)
+		}
+	}()
+
+	db = &UserDB{
+		users:     make(map[string]*PasswordHash),
+		digestKey: make([]byte, DigestKeySize),
+		verified:  make(map[string][sha256.Size]byte),
+	}
+
+	_, err = rand.Read(db.digestKey)
+	if err != nil {
+		return nil, err
+	}
+
+	sc := bufio.NewScanner(f)
+	var lineNumber int
+	var name string
+	var ph *PasswordHash
+	for sc.Scan() {
+		lineNumber++
+
+		name, ph, err = parseUserLine(sc.Text())
+		if err != nil {
+			return nil, fmt.Errorf(ErrUserFileSyntax, path, lineNumber, err)
+		}
+		if ph == nil {
+			continue
+		}
+
+		_, isDuplicate := db.users[name]
+		if isDuplicate {
+			err = fmt.Errorf(ErrDuplicateUserName, name)
+			return nil, fmt.Errorf(ErrUserFileSyntax, path, lineNumber, err)
+		}
+		db.users[name] = ph
+	}
+
+	err = sc.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	db.dummyHash, err = newDummyPasswordHash(db.users)
+	if err != nil {
+		return nil, err
+	}
+
+	return db, nil
+}
+
+// newDummyPasswordHash creates a hash of a random password whose
+// verification takes as long as the slowest verification of the users.
+func newDummyPasswordHash(users map[string]*PasswordHash) (ph *PasswordHash, err error) {
+	iterations := HashIterationsDefault
+	for _, userHash := range users {
+		iterations = max(iterations, userHash.Iterations)
+	}
+
+	return NewPasswordHash(rand.Text(), iterations)
+}
+
+func parseUserLine(line string) (name string, ph *PasswordHash, err error) {
+	line = strings.TrimSpace(line)
+	if (len(line) == 0) || strings.HasPrefix(line, UserFileCommentPrefix) {
+		return "", nil, nil
+	}
+
+	var hash string
+	var ok bool
+	name, hash, ok = strings.Cut(line, UserFileSeparator)
+	if !ok {
+		return "", nil, errors.New(ErrUserLineSyntax)
+	}
+	if len(name) == 0 {
+		return "", nil, errors.New(ErrUserNameIsEmpty)
+	}
+
+	ph, err = ParsePasswordHash(hash)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return name, ph, nil
+}
+
+// Authenticate checks the user's name and password.
+func (db *UserDB) Authenticate(name string, password string) (ok bool) {
+	ph, userExists := db.users[name]
+	if !userExists {
+		_ = db.dummyHash.Verify(password)
+		return false
+	}
+
+	digest := db.digest(name, password)
+
+	db.verifiedLock.RLock()
+	verifiedDigest, isVerified := db.verified[name]
+	db.verifiedLock.RUnlock()
+
+	if isVerified && (subtle.ConstantTimeCompare(digest[:], verifiedDigest[:]) == 1) {
+		return true
+	}
+
+	if !ph.Verify(password) {
+		return false
+	}
+
+	db.verifiedLock.Lock()
+	db.verified[name] = digest
+	db.verifiedLock.Unlock()
+
+	return true
+}
+
+// digest returns the keyed digest of the user's password.
+func (db *UserDB) digest(name string, password string) (digest [sha256.Size]byte) {
+	mac := hmac.New(sha256.New, db.digestKey)
+	mac.Write([]byte(name))
+	mac.Write([]byte{0})
+	mac.Write([]byte(password))
+	copy(digest[:], mac.Sum(nil))
+	return digest
+}
@@ -0,0 +1,65 @@
+package cl
+
+import (
+	"maps"
+	"sync"
+)
+
+// ConnectionLimiter counts simultaneous connections, both in total and per
+// key, e.g. per client, and limits their numbers. A zero limit means that
+// the number is not limited; connections are counted anyway.
+type ConnectionLimiter struct {
+	maxTotal  int
+	maxPerKey int
+
+	lock   sync.Mutex
+	total  int
+	perKey map[string]int
+}
+
+func New(maxTotal int, maxPerKey int) (cl *ConnectionLimiter) {
+	return &ConnectionLimiter{
+		maxTotal:  maxTotal,
+		maxPerKey: maxPerKey,
+		perKey:    make(map[string]int),
+	}
+}
+
+// Acquire registers a new connection of the key. When a limit has been
+// reached, the connection is not registered and false is returned. Every
+// successful call must be followed by a call of the Release method.
+func (cl *ConnectionLimiter) Acquire(key string) (ok bool) {
+	cl.lock.Lock()
+	defer cl.lock.Unlock()
+
+	if (cl.maxTotal > 0) && (cl.total >= cl.maxTotal) {
+		return false
+	}
+	if (cl.maxPerKey > 0) && (cl.perKey[key] >= cl.maxPerKey) {
+		return false
+	}
+
+	cl.total++
+	cl.perKey[key]++
+	return true
+}
+
+// Release unregisters a connection of the key.
+func (cl *ConnectionLimiter) Release(key string) {
+	cl.lock.Lock()
+	defer cl.lock.Unlock()
+
+	cl.total--
+	cl.perKey[key]--
+	if cl.perKey[key] <= 0 {
+		delete(cl.perKey, key)
+	}
+}
+
+// Count returns the current number of connections in total and per key.
+func (cl *ConnectionLimiter) Count() (total int, perKey map[string]int) {
+	cl.lock.Lock()
+	defer cl.lock.Unlock()
+
+	return cl.total, maps.Clone(cl.perKey)
+}
@@ -0,0 +1,240 @@
+package dp
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"strconv"
+	"strings"
+)
+
+const (
+	ErrPatternIsEmpty   = "pattern is empty"
+	ErrPatternSyntax    = "syntax error in pattern: %v"
+	ErrPortRangeSyntax  = "syntax error in port range: %v"
+	ErrPortRangeInverse = "port range is inverse: %v"
+)
+
+const (
+	PatternAnyHost        = "*"
+	PatternWildcardPrefix = "*."
+	PortRangeSeparator    = "-"
+)
+
+// Pattern is a pattern of a network destination, i.e. of a host and a port.
+// The following forms of a host are supported:
+//   - '*' matches any host;
+//   - 'example.com' matches exactly the 'example.com' host;
+//   - '*.example.com' matches all subdomains of 'example.com', but not the
+//     'example.com' itself;
+//   - '10.0.0.1' or '10.0.0.0/8' matches IP addresses; IPv6 addresses and
+//     networks with a port must be enclosed in square brackets, e.g.
+//     '[fd00::/8]:443'.
+//
+// Port is optional and is either a single port or a range, e.g. '8000-8999'.
+// Port may also be used without a host, e.g. ':25'. Host names are matched
+// case-insensitively. IP patterns match only destinations specified by an IP
+// address, host names are not resolved.
+type Pattern struct {
+	anyHost    bool
+	hostName   string
+	domain     string
+	prefix     netip.Prefix
+	isIPPrefix bool
+
+	anyPort bool
+	portMin uint16
+	portMax uint16
+}
+
+func ParsePattern(s string) (p *Pattern, err error) {
+	if len(s) == 0 {
+		return nil, errors.New(ErrPatternIsEmpty)
+	}
+
+	var host, port string
+	host, port, err = splitPattern(s)
+	if err != nil {
+		return nil, err
+	}
+
+	p = new(Pattern)
+
+	// Host.
+	switch {
+	case (len(host) == 0) || (host == PatternAnyHost):
+		p.anyHost = true
+
+	case strings.HasPrefix(host, PatternWildcardPrefix):
+		p.domain = NormaliseHostName(strings.TrimPrefix(host, PatternAnyHost))
+		if len(p.domain) <= 1 {
+			return nil, fmt.Errorf(ErrPatternSyntax, s)
+		}
+
+	case strings.Contains(host, "/"):
+		p.prefix, err = netip.ParsePrefix(host)
+		if err != nil {
+			return nil, err
+		}
+		p.prefix = unmapPrefix(p.prefix)
+		p.isIPPrefix = true
+
+	default:
+		addr, aerr := netip.ParseAddr(host)
+		if aerr == nil {
+			addr = addr.Unmap()
+			p.prefix = netip.PrefixFrom(addr, addr.BitLen())
+			p.isIPPrefix = true
+		} else {
+			if strings.ContainsAny(host, "*/[]") {
+				return nil, fmt.Errorf(ErrPatternSyntax, s)
+			}
+			p.hostName = NormaliseHostName(host)
+		}
+	}
+
+	// Port.
+	if len(port) == 0 {
+		p.anyPort = true
+		return p, nil
+	}
+
+	p.portMin, p.portMax, err = ParsePortRange(port)
+	if err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+// splitPattern splits the pattern into a host part and a port part.
+func splitPattern(s string) (host string, port string, err error) {
+	if strings.HasPrefix(s, "[") {
+		end := strings.Index(s, "]")
+		if end < 0 {
+			return "", "", fmt.Errorf(ErrPatternSyntax, s)
+		}
+
+		host = s[1:end]
+		rest := s[end+1:]
+		if len(rest) == 0 {
+			return host, "", nil
+		}
+		if !strings.HasPrefix(rest, ":") {
+			return "", "", fmt.Errorf(ErrPatternSyntax, s)
+		}
+
+		return host, rest[1:], nil
+	}
+
+	// IPv6 address or network without a port.
+	if strings.Count(s, ":") > 1 {
+		return s, "", nil
+	}
+
+	host, port, _ = strings.Cut(s, ":")
+	return host, port, nil
+}
+
+// ParsePortRange parses either a single port number or a range of port
+// numbers, e.g. '8000-8999'.
+func ParsePortRange(s string) (portMin uint16, portMax uint16, err error) {
+	minStr, maxStr, isRange := strings.Cut(s, PortRangeSeparator)
+
+	portMin, err = ParsePort(minStr)
+	if err != nil {
+		return 0, 0, fmt.Errorf(ErrPortRangeSyntax, s)
+	}
+	if !isRange {
+		return portMin, portMin, nil
+	}
+
+	portMax, err = ParsePort(maxStr)
+	if err != nil {
+		return 0, 0, fmt.Errorf(ErrPortRangeSyntax, s)
+	}
+	if portMax < portMin {
+		return 0, 0, fmt.Errorf(ErrPortRangeInverse, s)
+	}
+
+	return portMin, portMax, nil
+}
+
+func ParsePort(s string) (port uint16, err error) {
+	var u uint64
+	u, err = strconv.ParseUint(strings.TrimSpace(s), 10, 16)
+	if err != nil {
+		return 0, err
+	}
+
+	return uint16(u), nil
+}
+
+// NormaliseHostName converts the host name into a form used for comparison.
+func NormaliseHostName(host string) string {
+	return strings.TrimSuffix(strings.ToLower(host), ".")
+}
+
+func unmapPrefix(prefix netip.Prefix) netip.Prefix {
+	if prefix.Addr().Is4In6() && (prefix.Bits() >= 96) {
+		return netip.PrefixFrom(prefix.Addr().Unmap(), prefix.Bits()-96).Masked()
+	}
+	return prefix.Masked()
+}
+
+// Match checks whether the destination matches the pattern. Host must be
+// normalised with the NormaliseHostName function or be an IP address.
+func (p *Pattern) Match(host string, port uint16) bool {
+	if !p.anyPort && ((port < p.portMin) || (port > p.portMax)) {
+		return false
+	}
+
+	switch {
+	case p.anyHost:
+		return true
+
+	case p.isIPPrefix:
+		addr, err := netip.ParseAddr(host)
+		if err != nil {
+			return false
+		}
+		return p.prefix.Contains(addr.Unmap().WithZone(""))
+
+	case len(p.domain) > 0:
+		return strings.HasSuffix(host, p.domain)
+
+	default:
+		return host == p.hostName
+	}
+}
+
+func (p *Pattern) String() string {
+	var host string
+	switch {
+	case p.anyHost:
+		host = PatternAnyHost
+	case p.isIPPrefix && p.prefix.IsSingleIP():
+		host = p.prefix.Addr().String()
+	case p.isIPPrefix:
+		host = p.prefix.String()
+	case len(p.domain) > 0:
+		host = PatternAnyHost + p.domain
+	default:
+		host = p.hostName
+	}
+
+	if p.anyPort {
+		return host
+	}
+
+	if p.isIPPrefix && p.prefix.Addr().Is6() {
+		host = "[" + host + "]"
+	}
+
+	port := strconv.Itoa(int(p.portMin))
+	if p.portMax != p.portMin {
+		port += PortRangeSeparator + strconv.Itoa(int(p.portMax))
+	}
+
+	return host + ":" + port
+}
@@ -0,0 +1,158 @@
+package dp
+
+import (
+	"testing"
+)
+
+func Test_Pattern_Match(t *testing.T) {
+	type destination struct {
+		host string
+		port uint16
+	}
+
+	tests := []struct {
+		name       string
+		pattern    string
+		matched    []destination
+		notMatched []destination
+	}{
+		{
+			name:    "any host",
+			pattern: "*",
+			matched: []destination{{"example.com", 443}, {"10.0.0.1", 1}},
+		},
+		{
+			name:       "exact host",
+			pattern:    "Example.COM.",
+			matched:    []destination{{"example.com", 443}},
+			notMatched: []destination{{"www.example.com", 443}, {"badexample.com", 443}},
+		},
+		{
+			name:    "wildcard",
+			pattern: "*.example.com",
+			matched: []destination{{"www.example.com", 443}, {"a.b.example.com", 80}},
+			notMatched: []destination{
+				{"example.com", 443},
+				{"badexample.com", 443},
+				{"example.com.evil.org", 443},
+			},
+		},
+		{
+			name:       "apex without subdomains",
+			pattern:    "example.com",
+			matched:    []destination{{"example.com", 80}},
+			notMatched: []destination{{"www.example.com", 80}},
+		},
+		{
+			name:       "port",
+			pattern:    "example.com:443",
+			matched:    []destination{{"example.com", 443}},
+			notMatched: []destination{{"example.com", 80}, {"example.com", 444}},
+		},
+		{
+			name:       "port range",
+			pattern:    "*.example.com:8000-8999",
+			matched:    []destination{{"www.example.com", 8000}, {"www.example.com", 8999}},
+			notMatched: []destination{{"www.example.com", 7999}, {"www.example.com", 9000}},
+		},
+		{
+			name:       "port without host",
+			pattern:    ":25",
+			matched:    []destination{{"mail.example.com", 25}, {"10.0.0.1", 25}},
+			notMatched: []destination{{"mail.example.com", 587}},
+		},
+		{
+			name:       "IPv4 network",
+			pattern:    "10.0.0.0/8",
+			matched:    []destination{{"10.1.2.3", 80}, {"::ffff:10.1.2.3", 80}},
+			notMatched: []destination{{"11.0.0.1", 80}, {"ten.example.com", 80}},
+		},
+		{
+			name:       "IPv4 address",
+			pattern:    "10.0.0.1:22",
+			matched:    []destination{{"10.0.0.1", 22}},
+			notMatched: []destination{{"10.0.0.2", 22}, {"10.0.0.1", 23}},
+		},
+		{
+			name:       "IPv6 network with port",
+			pattern:    "[fd00::/8]:443",
+			matched:    []destination{{"fd12::1", 443}},
+			notMatched: []destination{{"fd12::1", 80}, {"fe80::1", 443}},
+		},
+		{
+			name:       "IPv6 address without port",
+			pattern:    "fd00::1",
+			matched:    []destination{{"fd00::1", 80}},
+			notMatched: []destination{{"fd00::2", 80}},
+		},
+		{
+			name:       "IPv4-mapped IPv6 network",
+			pattern:    "::ffff:10.0.0.0/104",
+			matched:    []destination{{"10.1.2.3", 80}},
+			notMatched: []destination{{"11.0.0.1", 80}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			p, err := ParsePattern(test.pattern)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			for _, d := range test.matched {
+				if !p.Match(d.host, d.port) {
+					t.Errorf("destination %v:%d is not matched", d.host, d.port)
+				}
+			}
+			for _, d := range test.notMatched {
+				if p.Match(d.host, d.port) {
+					t.Errorf("destination %v:%d is matched", d.host, d.port)
+				}
+			}
+		})
+	}
+}
+
+func Test_ParsePattern(t *testing.T) {
+	tests := []struct {
+		name           string
+		pattern        string
+		expectedString string
+		isErr          bool
+	}{
+		{name: "any host", pattern: "*", expectedString: "*"},
+		{name: "empty host", pattern: ":443", expectedString: "*:443"},
+		{name: "host name", pattern: "Example.com", expectedString: "example.com"},
+		{name: "wildcard", pattern: "*.Example.com:443", expectedString: "*.example.com:443"},
+		{name: "port range", pattern: "example.com:8000-8999", expectedString: "example.com:8000-8999"},
+		{name: "network with host bits", pattern: "10.1.2.3/8", expectedString: "10.0.0.0/8"},
+		{name: "IPv6 address", pattern: "[FD00::1]:443", expectedString: "[fd00::1]:443"},
+		{name: "empty", pattern: "", isErr: true},
+		{name: "bare wildcard domain", pattern: "*.", isErr: true},
+		{name: "wildcard inside host", pattern: "www.*.com", isErr: true},
+		{name: "bad network", pattern: "10.0.0.0/33", isErr: true},
+		{name: "unclosed bracket", pattern: "[fd00::1:443", isErr: true},
+		{name: "garbage after bracket", pattern: "[fd00::1]443", isErr: true},
+		{name: "bad port", pattern: "example.com:http", isErr: true},
+		{name: "port out of range", pattern: "example.com:65536", isErr: true},
+		{name: "inverse port range", pattern: "example.com:9000-8000", isErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			p, err := ParsePattern(test.pattern)
+			if test.isErr {
+				if err == nil {
+					t.Fatalf("error is expected, pattern is %v", p)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if p.String() != test.expectedString {
+				t.Errorf("pattern is %q, expected %q", p.String(), test.expectedString)
+			}
+		})
+	}
+}
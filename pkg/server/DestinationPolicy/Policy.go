@@ -0,0 +1,151 @@
+package dp
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	ae "github.com/vault-thirteen/auxie/errors"
+)
+
+const (
+	ErrPolicyFileSyntax = "destination policy file '%v', line %d: %w"
+	ErrRuleSyntax       = "syntax error, expected '<action> <pattern>'"
+	ErrUnknownAction    = "unknown action: %v"
+)
+
+const (
+	PolicyCommentPrefix = "#"
+)
+
+// Action.
+const (
+	ActionAllow = "allow"
+	ActionDeny  = "deny"
+)
+
+// Rule is a rule of the destination policy.
+type Rule struct {
+	IsAllowed bool
+	Pattern   *Pattern
+}
+
+// Policy is an ordered list of rules for destinations of proxied requests.
+// Rules are checked in order, the first matching rule decides. When no rule
+// matches, the default action is applied.
+type Policy struct {
+	rules            []*Rule
+	isAllowedDefault bool
+}
+
+// NewPolicyFromFile reads the destination policy from a text file. Each line
+// of the file has the following format:
+//
+//	<allow|deny> <pattern>
+//
+// Empty lines and lines starting with '#' are ignored. See the Pattern type
+// for the syntax of patterns.
+func NewPolicyFromFile(path string, defaultAction string) (p *Policy, err error) {
+	p = new(Policy)
+	p.isAllowedDefault, err = parseAction(defaultAction)
+	if err != nil {
+		return nil, err
+	}
+
+	var f *os.File
+	f, err = os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		derr := f.Close()
+		if derr != nil {
+			err = ae.Combine(err, derr)
+		}
+	}()
+
+	sc := bufio.NewScanner(f)
+	var lineNumber int
+	var rule *Rule
+	for sc.Scan() {
+		lineNumber++
+
+		rule, err = parseRule(sc.Text())
+		if err != nil {
+			return nil, fmt.Errorf(ErrPolicyFileSyntax, path, lineNumber, err)
+		}
+		if rule == nil {
+			continue
+		}
+
+		p.rules = append(p.rules, rule)
+	}
+
+	err = sc.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+func parseRule(line string) (rule *Rule, err error) {
+	line = strings.TrimSpace(line)
+	if (len(line) == 0) || strings.HasPrefix(line, PolicyCommentPrefix) {
+		return nil, nil
+	}
+
+	fields := strings.Fields(line)
+	if len(fields) != 2 {
+		return nil, errors.New(ErrRuleSyntax)
+	}
+
+	rule = new(Rule)
+	rule.IsAllowed, err = parseAction(fields[0])
+	if err != nil {
+		return nil, err
+	}
+
+	rule.Pattern, err = ParsePattern(fields[1])
+	if err != nil {
+		return nil, err
+	}
+
+	return rule, nil
+}
+
+func parseAction(action string) (isAllowed bool, err error) {
+	switch strings.ToLower(action) {
+	case ActionAllow:
+		return true, nil
+	case ActionDeny:
+		return false, nil
+	default:
+		return false, fmt.Errorf(ErrUnknownAction, action)
+	}
+}
+
+// IsAllowed checks whether the destination is allowed by the policy. The
+// matched rule is returned for logging; it is nil when the default action
+// has been applied.
+func (p *Policy) IsAllowed(host string, port uint16) (isAllowed bool, rule *Rule) {
+	host = NormaliseHostName(host)
+
+	for _, rule = range p.rules {
+		if rule.Pattern.Match(host, port) {
+			return rule.IsAllowed, rule
+		}
+	}
+
+	return p.isAllowedDefault, nil
+}
+
+func (r *Rule) String() string {
+	if r.IsAllowed {
+		return ActionAllow + " " + r.Pattern.String()
+	}
+	return ActionDeny + " " + r.Pattern.String()
+}
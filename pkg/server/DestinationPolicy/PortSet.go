@@ -0,0 +1,59 @@
+package dp
+
+import (
+	"strings"
+)
+
+const (
+	PortSetAny       = "*"
+	PortSetSeparator = ","
+)
+
+// PortSet is a set of port numbers. In text form, it is a comma-separated
+// list of ports and port ranges, e.g. '443,8443,9000-9099'. The '*' symbol
+// means any port.
+type PortSet struct {
+	isAny  bool
+	ranges [][2]uint16
+}
+
+func ParsePortSet(s string) (ps *PortSet, err error) {
+	ps = new(PortSet)
+
+	s = strings.TrimSpace(s)
+	if s == PortSetAny {
+		ps.isAny = true
+		return ps, nil
+	}
+
+	var portMin, portMax uint16
+	for _, part := range strings.Split(s, PortSetSeparator) {
+		part = strings.TrimSpace(part)
+		if len(part) == 0 {
+			continue
+		}
+
+		portMin, portMax, err = ParsePortRange(part)
+		if err != nil {
+			return nil, err
+		}
+
+		ps.ranges = append(ps.ranges, [2]uint16{portMin, portMax})
+	}
+
+	return ps, nil
+}
+
+func (ps *PortSet) Contains(port uint16) bool {
+	if ps.isAny {
+		return true
+	}
+
+	for _, r := range ps.ranges {
+		if (port >= r[0]) && (port <= r[1]) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,78 @@
+package dp
+
+import (
+	"testing"
+)
+
+func Test_PortSet_Contains(t *testing.T) {
+	tests := []struct {
+		name         string
+		set          string
+		contained    []uint16
+		notContained []uint16
+		isErr        bool
+	}{
+		{
+			name:      "any port",
+			set:       " * ",
+			contained: []uint16{0, 1, 443, 65535},
+		},
+		{
+			name:         "single port",
+			set:          "443",
+			contained:    []uint16{443},
+			notContained: []uint16{442, 444},
+		},
+		{
+			name:         "ports and ranges",
+			set:          "443, 8443,9000-9099,",
+			contained:    []uint16{443, 8443, 9000, 9050, 9099},
+			notContained: []uint16{80, 8999, 9100},
+		},
+		{
+			name:         "range of a single port",
+			set:          "25-25",
+			contained:    []uint16{25},
+			notContained: []uint16{24, 26},
+		},
+		{
+			name:      "full range",
+			set:       "0-65535",
+			contained: []uint16{0, 65535},
+		},
+		{
+			name:         "empty",
+			set:          "",
+			notContained: []uint16{0, 443},
+		},
+		{name: "bad port", set: "443,https", isErr: true},
+		{name: "port out of range", set: "65536", isErr: true},
+		{name: "inverse range", set: "9099-9000", isErr: true},
+		{name: "open range", set: "9000-", isErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ps, err := ParsePortSet(test.set)
+			if test.isErr {
+				if err == nil {
+					t.Fatalf("error is expected")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			for _, port := range test.contained {
+				if !ps.Contains(port) {
+					t.Errorf("port %d is not contained", port)
+				}
+			}
+			for _, port := range test.notContained {
+				if ps.Contains(port) {
+					t.Errorf("port %d is contained", port)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,130 @@
+package pp
+
+import (
+	"bufio"
+	"net"
+	"sync"
+	"time"
+
+	relay "github.com/vault-thirteen/Forward-Proxy/pkg/server/Relay"
+)
+
+// Listener accepts connections which start with a header of the PROXY
+// protocol, version 1 or 2, sent by a load balancer. The header carries the
+// address of the original client, which is then returned by the RemoteAddr
+// method of the connection.
+//
+// The header is read lazily by the goroutine serving the connection, on the
+// first call of the Read or the RemoteAddr method, so that a slow client
+// does not block accepting of other connections.
+type Listener struct {
+	net.Listener
+
+	headerTimeout time.Duration
+
+	// isPeerTrusted checks the direct peer, i.e. the load balancer. Headers
+	// from untrusted peers are not accepted.
+	isPeerTrusted func(addr net.Addr) bool
+}
+
+// NewListener wraps the listener. When isPeerTrusted is nil, all peers are
+// trusted.
+func NewListener(l net.Listener, headerTimeout time.Duration, isPeerTrusted func(addr net.Addr) bool) *Listener {
+	return &Listener{
+		Listener:      l,
+		headerTimeout: headerTimeout,
+		isPeerTrusted: isPeerTrusted,
+	}
+}
+
+func (l *Listener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	return &Conn{
+		Conn:     conn,
+		listener: l,
+		reader:   bufio.NewReader(conn),
+	}, nil
+}
+
+// Conn is a connection accepted by the Listener.
+type Conn struct {
+	net.Conn
+
+	listener   *Listener
+	reader     *bufio.Reader
+	headerOnce sync.Once
+	headerErr  error
+	sourceAddr net.Addr
+}
+
+func (c *Conn) Read(b []byte) (n int, err error) {
+	c.headerOnce.Do(c.readHeader)
+	if c.headerErr != nil {
+		return 0, c.headerErr
+	}
+
+	return c.reader.Read(b)
+}
+
+// RemoteAddr returns the address of the original client taken from the
+// header. When the header carries no address, the address of the direct
+// peer is returned.
+func (c *Conn) RemoteAddr() net.Addr {
+	c.headerOnce.Do(c.readHeader)
+	if c.sourceAddr != nil {
+		return c.sourceAddr
+	}
+
+	return c.Conn.RemoteAddr()
+}
+
+// PeerAddr returns the address of the direct peer, i.e. of the load balancer.
+func (c *Conn) PeerAddr() net.Addr {
+	return c.Conn.RemoteAddr()
+}
+
+// CloseWrite shuts down the writing side of the connection when the
+// underlying connection supports it.
+func (c *Conn) CloseWrite() (err error) {
+	return relay.CloseWrite(c.Conn)
+}
+
+func (c *Conn) readHeader() {
+	if (c.listener.isPeerTrusted != nil) && !c.listener.isPeerTrusted(c.Conn.RemoteAddr()) {
+		c.headerErr = &UntrustedPeerError{Addr: c.Conn.RemoteAddr()}
+		_ = c.Conn.Close()
+		return
+	}
+
+	if c.listener.headerTimeout > 0 {
+		err := c.Conn.SetReadDeadline(time.Now().Add(c.listener.headerTimeout))
+		if err != nil {
+			c.headerErr = err
+			return
+		}
+	}
+
+	c.sourceAddr, c.headerErr = readHeader(c.reader)
+	if c.headerErr != nil {
+		_ = c.Conn.Close()
+		return
+	}
+
+	if c.listener.headerTimeout > 0 {
+		c.headerErr = c.Conn.SetReadDeadline(time.Time{})
+	}
+}
+
+// UntrustedPeerError is returned when a peer which is not trusted connects
+// to the Listener.
+type UntrustedPeerError struct {
+	Addr net.Addr
+}
+
+func (e *UntrustedPeerError) Error() string {
+	return "PROXY protocol peer is not trusted: " + e.Addr.String()
+}
@@ -0,0 +1,180 @@
+package pp
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+)
+
+const (
+	ErrHeaderIsMissing        = "PROXY protocol header is missing"
+	ErrHeaderV1Syntax         = "syntax error in PROXY protocol v1 header"
+	ErrHeaderV1IsTooLong      = "PROXY protocol v1 header is too long"
+	ErrUnsupportedVersion     = "unsupported PROXY protocol version: %v"
+	ErrUnsupportedCommand     = "unsupported PROXY protocol command: %v"
+	ErrAddressBlockIsTooShort = "PROXY protocol address block is too short"
+)
+
+const (
+	// HeaderV1Prefix is a prefix of the text header of the PROXY protocol
+	// version 1.
+	HeaderV1Prefix = "PROXY "
+
+	// HeaderV1MaxLength is a maximal length of the version 1 header
+	// including the CRLF.
+	HeaderV1MaxLength = 107
+
+	HeaderV1ProtocolTcp4    = "TCP4"
+	HeaderV1ProtocolTcp6    = "TCP6"
+	HeaderV1ProtocolUnknown = "UNKNOWN"
+)
+
+// HeaderV2Signature is a signature of the binary header of the PROXY protocol
+// version 2.
+var HeaderV2Signature = []byte{0x0D, 0x0A, 0x0D, 0x0A, 0x00, 0x0D, 0x0A, 0x51, 0x55, 0x49, 0x54, 0x0A}
+
+const (
+	HeaderV2FixedPartLength = 16
+	HeaderV2Version         = 0x2
+	HeaderV2CommandLocal    = 0x0
+	HeaderV2CommandProxy    = 0x1
+	HeaderV2FamilyInet      = 0x1
+	HeaderV2FamilyInet6     = 0x2
+	HeaderV2AddressLength4  = 12
+	HeaderV2AddressLength6  = 36
+)
+
+// readHeader reads the PROXY protocol header of either version from the
+// stream. When the header carries no address of the original client, e.g.
+// for health checks of a load balancer, the returned address is nil.
+func readHeader(r *bufio.Reader) (sourceAddr net.Addr, err error) {
+	var prefix []byte
+	prefix, err = r.Peek(len(HeaderV1Prefix))
+	if err != nil {
+		return nil, err
+	}
+
+	if string(prefix) == HeaderV1Prefix {
+		return readHeaderV1(r)
+	}
+
+	prefix, err = r.Peek(len(HeaderV2Signature))
+	if err != nil {
+		return nil, err
+	}
+
+	if bytes.Equal(prefix, HeaderV2Signature) {
+		return readHeaderV2(r)
+	}
+
+	return nil, errors.New(ErrHeaderIsMissing)
+}
+
+// readHeaderV1 reads the text header, e.g.
+// 'PROXY TCP4 192.168.0.1 192.168.0.11 56324 443\r\n'.
+func readHeaderV1(r *bufio.Reader) (sourceAddr net.Addr, err error) {
+	var line []byte
+	for len(line) < HeaderV1MaxLength {
+		var b byte
+		b, err = r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+
+		line = append(line, b)
+		if bytes.HasSuffix(line, []byte("\r\n")) {
+			break
+		}
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, errors.New(ErrHeaderV1IsTooLong)
+	}
+
+	fields := strings.Split(strings.TrimSuffix(string(line), "\r\n"), " ")
+	if (len(fields) >= 2) && (fields[1] == HeaderV1ProtocolUnknown) {
+		return nil, nil
+	}
+	if len(fields) != 6 {
+		return nil, errors.New(ErrHeaderV1Syntax)
+	}
+
+	var addr netip.Addr
+	addr, err = netip.ParseAddr(fields[2])
+	if err != nil {
+		return nil, err
+	}
+	if ((fields[1] == HeaderV1ProtocolTcp4) && !addr.Is4()) ||
+		((fields[1] == HeaderV1ProtocolTcp6) && !addr.Is6()) ||
+		((fields[1] != HeaderV1ProtocolTcp4) && (fields[1] != HeaderV1ProtocolTcp6)) {
+		return nil, errors.New(ErrHeaderV1Syntax)
+	}
+
+	var port uint64
+	port, err = strconv.ParseUint(fields[4], 10, 16)
+	if err != nil {
+		return nil, err
+	}
+
+	return net.TCPAddrFromAddrPort(netip.AddrPortFrom(addr, uint16(port))), nil
+}
+
+// readHeaderV2 reads the binary header.
+func readHeaderV2(r *bufio.Reader) (sourceAddr net.Addr, err error) {
+	fixedPart := make([]byte, HeaderV2FixedPartLength)
+	_, err = io.ReadFull(r, fixedPart)
+	if err != nil {
+		return nil, err
+	}
+
+	version := fixedPart[12] >> 4
+	command := fixedPart[12] & 0x0F
+	family := fixedPart[13] >> 4
+	length := binary.BigEndian.Uint16(fixedPart[14:16])
+
+	if version != HeaderV2Version {
+		return nil, fmt.Errorf(ErrUnsupportedVersion, version)
+	}
+
+	addressBlock := make([]byte, length)
+	_, err = io.ReadFull(r, addressBlock)
+	if err != nil {
+		return nil, err
+	}
+
+	switch command {
+	case HeaderV2CommandLocal:
+		return nil, nil
+	case HeaderV2CommandProxy:
+	default:
+		return nil, fmt.Errorf(ErrUnsupportedCommand, command)
+	}
+
+	switch family {
+	case HeaderV2FamilyInet:
+		if len(addressBlock) < HeaderV2AddressLength4 {
+			return nil, errors.New(ErrAddressBlockIsTooShort)
+		}
+		addr := netip.AddrFrom4([4]byte(addressBlock[0:4]))
+		port := binary.BigEndian.Uint16(addressBlock[8:10])
+		return net.TCPAddrFromAddrPort(netip.AddrPortFrom(addr, port)), nil
+
+	case HeaderV2FamilyInet6:
+		if len(addressBlock) < HeaderV2AddressLength6 {
+			return nil, errors.New(ErrAddressBlockIsTooShort)
+		}
+		addr := netip.AddrFrom16([16]byte(addressBlock[0:16]))
+		port := binary.BigEndian.Uint16(addressBlock[32:34])
+		return net.TCPAddrFromAddrPort(netip.AddrPortFrom(addr, port)), nil
+
+	default:
+		// Unix sockets and unspecified families carry no IP address.
+		return nil, nil
+	}
+}
@@ -0,0 +1,216 @@
+package pp
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"strings"
+	"testing"
+)
+
+// headerV2 builds a binary header with the address block.
+func headerV2(versionCommand byte, familyProtocol byte, addressBlock []byte) []byte {
+	buf := append([]byte{}, HeaderV2Signature...)
+	buf = append(buf, versionCommand, familyProtocol)
+	buf = binary.BigEndian.AppendUint16(buf, uint16(len(addressBlock)))
+	return append(buf, addressBlock...)
+}
+
+func Test_readHeader(t *testing.T) {
+	addressBlock4 := []byte{
+		192, 168, 0, 1, // Source address.
+		192, 168, 0, 11, // Destination address.
+		0xDC, 0x04, // Source port, 56324.
+		0x01, 0xBB, // Destination port, 443.
+	}
+	addressBlock6 := make([]byte, HeaderV2AddressLength6)
+	addressBlock6[0], addressBlock6[1], addressBlock6[15] = 0x20, 0x01, 0x01
+	addressBlock6[32], addressBlock6[33] = 0x1F, 0x90
+
+	truncatedLength := headerV2(0x21, 0x11, addressBlock4)
+	binary.BigEndian.PutUint16(truncatedLength[14:16], 0xFFFF)
+
+	tests := []struct {
+		name         string
+		header       []byte
+		expectedAddr string
+		isErr        bool
+	}{
+		{
+			name:         "v1 TCP4",
+			header:       []byte("PROXY TCP4 192.168.0.1 192.168.0.11 56324 443\r\n"),
+			expectedAddr: "192.168.0.1:56324",
+		},
+		{
+			name:         "v1 TCP6",
+			header:       []byte("PROXY TCP6 2001::1 2001::2 8080 443\r\n"),
+			expectedAddr: "[2001::1]:8080",
+		},
+		{
+			name:   "v1 UNKNOWN",
+			header: []byte("PROXY UNKNOWN\r\n"),
+		},
+		{
+			name:   "v1 UNKNOWN with addresses",
+			header: []byte("PROXY UNKNOWN ffff::1 ffff::2 1 2\r\n"),
+		},
+		{
+			name:   "v1 truncated",
+			header: []byte("PROXY TCP4 192.168.0.1 192.168"),
+			isErr:  true,
+		},
+		{
+			name:   "v1 without CRLF",
+			header: []byte("PROXY TCP4 192.168.0.1 192.168.0.11 56324 443\n"),
+			isErr:  true,
+		},
+		{
+			name:   "v1 too long",
+			header: []byte("PROXY TCP4 " + strings.Repeat("1", HeaderV1MaxLength) + "\r\n"),
+			isErr:  true,
+		},
+		{
+			name:   "v1 unknown protocol",
+			header: []byte("PROXY UDP4 192.168.0.1 192.168.0.11 56324 443\r\n"),
+			isErr:  true,
+		},
+		{
+			name:   "v1 address of wrong family",
+			header: []byte("PROXY TCP4 2001::1 2001::2 8080 443\r\n"),
+			isErr:  true,
+		},
+		{
+			name:   "v1 missing field",
+			header: []byte("PROXY TCP4 192.168.0.1 192.168.0.11 56324\r\n"),
+			isErr:  true,
+		},
+		{
+			name:   "v1 oversized port",
+			header: []byte("PROXY TCP4 192.168.0.1 192.168.0.11 65536 443\r\n"),
+			isErr:  true,
+		},
+		{
+			name:   "v1 invalid address",
+			header: []byte("PROXY TCP4 192.168.0.256 192.168.0.11 56324 443\r\n"),
+			isErr:  true,
+		},
+		{
+			name:         "v2 PROXY IPv4",
+			header:       headerV2(0x21, 0x11, addressBlock4),
+			expectedAddr: "192.168.0.1:56324",
+		},
+		{
+			name:         "v2 PROXY IPv6",
+			header:       headerV2(0x21, 0x21, addressBlock6),
+			expectedAddr: "[2001::1]:8080",
+		},
+		{
+			name:         "v2 PROXY IPv4 with TLVs",
+			header:       headerV2(0x21, 0x11, append(append([]byte{}, addressBlock4...), 0x04, 0x00, 0x01, 0x00)),
+			expectedAddr: "192.168.0.1:56324",
+		},
+		{
+			name:   "v2 LOCAL",
+			header: headerV2(0x20, 0x00, nil),
+		},
+		{
+			name:   "v2 LOCAL with address block",
+			header: headerV2(0x20, 0x11, addressBlock4),
+		},
+		{
+			name:   "v2 unspecified family",
+			header: headerV2(0x21, 0x00, nil),
+		},
+		{
+			name:   "v2 unknown family",
+			header: headerV2(0x21, 0x41, addressBlock4),
+		},
+		{
+			name:   "v2 truncated signature",
+			header: HeaderV2Signature[:8],
+			isErr:  true,
+		},
+		{
+			name:   "v2 truncated fixed part",
+			header: headerV2(0x21, 0x11, addressBlock4)[:14],
+			isErr:  true,
+		},
+		{
+			name:   "v2 truncated address block",
+			header: headerV2(0x21, 0x11, addressBlock4)[:20],
+			isErr:  true,
+		},
+		{
+			name:   "v2 oversized length",
+			header: truncatedLength,
+			isErr:  true,
+		},
+		{
+			name:   "v2 IPv4 address block is too short",
+			header: headerV2(0x21, 0x11, addressBlock4[:8]),
+			isErr:  true,
+		},
+		{
+			name:   "v2 IPv6 address block is too short",
+			header: headerV2(0x21, 0x21, addressBlock4),
+			isErr:  true,
+		},
+		{
+			name:   "v2 unsupported version",
+			header: headerV2(0x11, 0x11, addressBlock4),
+			isErr:  true,
+		},
+		{
+			name:   "v2 unsupported command",
+			header: headerV2(0x22, 0x11, addressBlock4),
+			isErr:  true,
+		},
+		{
+			name:   "missing header",
+			header: []byte("GET / HTTP/1.1\r\nHost: example.com\r\n\r\n"),
+			isErr:  true,
+		},
+		{
+			name:   "empty stream",
+			header: nil,
+			isErr:  true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			payload := []byte("payload")
+			r := bufio.NewReader(bytes.NewReader(append(append([]byte{}, test.header...), payload...)))
+
+			sourceAddr, err := readHeader(r)
+			if test.isErr {
+				if err == nil {
+					t.Fatalf("error is expected, address is %v", sourceAddr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			var addr string
+			if sourceAddr != nil {
+				addr = sourceAddr.String()
+			}
+			if addr != test.expectedAddr {
+				t.Errorf("address is %q, expected %q", addr, test.expectedAddr)
+			}
+
+			// The header is consumed entirely, the data of the client
+			// follows it.
+			rest, err := io.ReadAll(r)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !bytes.Equal(rest, payload) {
+				t.Errorf("data after the header is %q, expected %q", rest, payload)
+			}
+		})
+	}
+}
@@ -0,0 +1,90 @@
+package rl
+
+import (
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// RateLimiter limits the rate of requests separately for each key, e.g. for
+// each client. Every key has its own token bucket.
+//
+// A bucket which has been idle long enough to become full again is the same
+// as a new bucket, so such buckets are removed by the Cleanup method. This
+// keeps the memory usage proportional to the number of active keys.
+type RateLimiter struct {
+	limit rate.Limit
+	burst int
+
+	lock    sync.Mutex
+	buckets map[string]*rate.Limiter
+}
+
+// New creates a rate limiter. Rate is a sustained number of requests per
+// second, burst is a maximal number of requests made at once.
+func New(requestsPerSec float64, burst int) (rl *RateLimiter) {
+	if burst < 1 {
+		burst = 1
+	}
+
+	return &RateLimiter{
+		limit:   rate.Limit(requestsPerSec),
+		burst:   burst,
+		buckets: make(map[string]*rate.Limiter),
+	}
+}
+
+// Allow takes a token from the key's bucket. When the bucket is empty, the
+// request is not allowed and the time after which a token will be available
+// is returned.
+func (rl *RateLimiter) Allow(key string) (ok bool, retryAfter time.Duration) {
+	now := time.Now()
+
+	rl.lock.Lock()
+	bucket, exists := rl.buckets[key]
+	if !exists {
+		bucket = rate.NewLimiter(rl.limit, rl.burst)
+		rl.buckets[key] = bucket
+	}
+	rl.lock.Unlock()
+
+	r := bucket.ReserveN(now, 1)
+	if !r.OK() {
+		return false, time.Second
+	}
+
+	retryAfter = r.DelayFrom(now)
+	if retryAfter > 0 {
+		r.CancelAt(now)
+		return false, retryAfter
+	}
+
+	return true, 0
+}
+
+// Cleanup removes buckets which have become full, i.e. buckets of keys which
+// have been idle for a long time.
+func (rl *RateLimiter) Cleanup() (removedCount int) {
+	now := time.Now()
+
+	rl.lock.Lock()
+	defer rl.lock.Unlock()
+
+	for key, bucket := range rl.buckets {
+		if bucket.TokensAt(now) >= float64(rl.burst) {
+			delete(rl.buckets, key)
+			removedCount++
+		}
+	}
+
+	return removedCount
+}
+
+// Size returns the number of buckets in use.
+func (rl *RateLimiter) Size() int {
+	rl.lock.Lock()
+	defer rl.lock.Unlock()
+
+	return len(rl.buckets)
+}
@@ -0,0 +1,31 @@
+package relay
+
+import (
+	"bufio"
+	"net"
+)
+
+// BufferedConn is a connection whose beginning has been read into a buffer,
+// e.g. while a protocol is detected or a handshake is read. Data are read
+// from the buffer first.
+type BufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+// NewBufferedConn creates a connection which reads data through the reader.
+// The reader must read from the connection.
+func NewBufferedConn(conn net.Conn, reader *bufio.Reader) *BufferedConn {
+	return &BufferedConn{
+		Conn:   conn,
+		reader: reader,
+	}
+}
+
+func (c *BufferedConn) Read(b []byte) (n int, err error) {
+	return c.reader.Read(b)
+}
+
+func (c *BufferedConn) CloseWrite() (err error) {
+	return CloseWrite(c.Conn)
+}
@@ -0,0 +1,69 @@
+package relay
+
+import (
+	"io"
+	"time"
+)
+
+// halfCloser is a connection whose writing side may be shut down while its
+// reading side stays open, e.g. a TCP connection.
+type halfCloser interface {
+	CloseWrite() error
+}
+
+// readDeadliner is a connection whose reading may be interrupted.
+type readDeadliner interface {
+	SetReadDeadline(t time.Time) error
+}
+
+// Pipe copies data between the client and the target in both directions
+// until both sides have sent all their data. Each direction is copied by the
+// copyData function in a separate goroutine, the function returns when the
+// source ends. When one side ends its data, the connection of the other side
+// is half-closed, so that the other side still may send its reply.
+func Pipe(client, target io.ReadWriter, copyData func(dst io.Writer, src io.Reader)) {
+	toTarget := make(chan bool, 1)
+	toClient := make(chan bool, 1)
+	go func() {
+		copyData(target, client)
+		toTarget <- true
+	}()
+	go func() {
+		copyData(client, target)
+		toClient <- true
+	}()
+
+	for range 2 {
+		select {
+		case <-toTarget:
+			endData(target)
+		case <-toClient:
+			endData(client)
+		}
+	}
+}
+
+// endData tells the peer of the connection that no more data is sent to it.
+// A connection which can not be half-closed, e.g. a stream of HTTP/2, is not
+// needed any more, so its reading is interrupted.
+func endData(conn io.ReadWriter) {
+	if hc, ok := conn.(halfCloser); ok {
+		_ = hc.CloseWrite()
+		return
+	}
+
+	if rd, ok := conn.(readDeadliner); ok {
+		_ = rd.SetReadDeadline(time.Now())
+	}
+}
+
+// CloseWrite shuts down the writing side of the connection when the
+// connection supports it. It is used by wrappers of connections to pass the
+// half-close to the wrapped connection.
+func CloseWrite(conn io.Writer) (err error) {
+	if hc, ok := conn.(halfCloser); ok {
+		return hc.CloseWrite()
+	}
+
+	return nil
+}
@@ -0,0 +1,345 @@
+package routing
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net/netip"
+	"os"
+	"strings"
+
+	ae "github.com/vault-thirteen/auxie/errors"
+
+	dp "github.com/vault-thirteen/Forward-Proxy/pkg/server/DestinationPolicy"
+	upstream "github.com/vault-thirteen/Forward-Proxy/pkg/server/Upstream"
+	wm "github.com/vault-thirteen/Forward-Proxy/pkg/server/WorkMode"
+)
+
+const (
+	ErrTableFileSyntax      = "routing table file '%v', line %d: %w"
+	ErrProxySyntax          = "syntax error, expected 'proxy <name> <url>'"
+	ErrPoolSyntax           = "syntax error, expected 'pool <name> <round-robin|least-connections> <proxy names>'"
+	ErrRouteSyntax          = "syntax error, expected 'route <pattern> [from <networks>] <direct|proxy name|pool name>'"
+	ErrUnknownKeyword       = "unknown keyword: %v"
+	ErrUnknownProxy         = "unknown proxy: %v"
+	ErrUnknownProxyOrPool   = "unknown proxy or pool: %v"
+	ErrDuplicateName        = "duplicate name of proxy or pool: %v"
+	ErrReservedName         = "reserved name: %v"
+	ErrClientNetworksSyntax = "syntax error in client networks: %w"
+)
+
+const (
+	TableCommentPrefix     = "#"
+	ClientNetworkSeparator = ","
+	PoolMemberSeparator    = ","
+)
+
+// Keywords.
+const (
+	KeywordProxy  = "proxy"
+	KeywordPool   = "pool"
+	KeywordRoute  = "route"
+	KeywordFrom   = "from"
+	KeywordDirect = "direct"
+)
+
+// Route is a route of the routing table. A route matches a destination and,
+// optionally, networks of clients. A route leads either to a single proxy or
+// to a pool of proxies; both are represented by a pool. Pool is nil when the
+// route is direct.
+type Route struct {
+	Pattern    *dp.Pattern
+	Clients    []netip.Prefix
+	TargetName string
+	Pool       *upstream.Pool
+}
+
+// Table is an ordered list of routes for destinations of proxied requests.
+// Routes are checked in order, the first matching route decides.
+type Table struct {
+	routes []*Route
+
+	// Pools declared with the 'pool' keyword.
+	pools []*upstream.Pool
+}
+
+// declarations are the proxies and the pools declared in the file.
+type declarations struct {
+	proxies map[string]*upstream.Proxy
+	targets map[string]*upstream.Pool
+}
+
+// NewTableFromFile reads the routing table from a text file. The file
+// declares upstream proxies and routes, one per line:
+//
+//	proxy <name> <url>
+//	pool <name> <round-robin|least-connections> <proxy names>
+//	route <pattern> [from <networks>] <direct|name>
+//
+// Proxy names of a pool are separated by commas. Proxies and pools must be
+// declared before they are used. Networks are a comma-separated
+// list of IP addresses and networks of clients. Empty lines and lines
+// starting with '#' are ignored. See the Pattern type of the destination
+// policy for the syntax of patterns.
+func NewTableFromFile(path string) (t *Table, err error) {
+	var f *os.File
+	f, err = os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		derr := f.Close()
+		if derr != nil {
+			err = ae.Combine(err, derr)
+		}
+	}()
+
+	t = new(Table)
+	decls := &declarations{
+		proxies: make(map[string]*upstream.Proxy),
+		targets: make(map[string]*upstream.Pool),
+	}
+	sc := bufio.NewScanner(f)
+	var lineNumber int
+	for sc.Scan() {
+		lineNumber++
+
+		err = t.parseLine(sc.Text(), decls)
+		if err != nil {
+			return nil, fmt.Errorf(ErrTableFileSyntax, path, lineNumber, err)
+		}
+	}
+
+	err = sc.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	return t, nil
+}
+
+func (t *Table) parseLine(line string, decls *declarations) (err error) {
+	line = strings.TrimSpace(line)
+	if (len(line) == 0) || strings.HasPrefix(line, TableCommentPrefix) {
+		return nil
+	}
+
+	fields := strings.Fields(line)
+	switch strings.ToLower(fields[0]) {
+	case KeywordProxy:
+		return decls.parseProxy(fields[1:])
+
+	case KeywordPool:
+		var pool *upstream.Pool
+		pool, err = decls.parsePool(fields[1:])
+		if err != nil {
+			return err
+		}
+
+		t.pools = append(t.pools, pool)
+		return nil
+
+	case KeywordRoute:
+		var route *Route
+		route, err = decls.parseRoute(fields[1:])
+		if err != nil {
+			return err
+		}
+
+		t.routes = append(t.routes, route)
+		return nil
+
+	default:
+		return fmt.Errorf(ErrUnknownKeyword, fields[0])
+	}
+}
+
+func (d *declarations) parseProxy(fields []string) (err error) {
+	if len(fields) != 2 {
+		return errors.New(ErrProxySyntax)
+	}
+
+	name := fields[0]
+	err = d.checkName(name)
+	if err != nil {
+		return err
+	}
+
+	var proxy *upstream.Proxy
+	proxy, err = upstream.Parse(fields[1])
+	if err != nil {
+		return err
+	}
+
+	d.proxies[name] = proxy
+	d.targets[name] = upstream.NewSingleProxyPool(name, proxy)
+
+	return nil
+}
+
+func (d *declarations) parsePool(fields []string) (pool *upstream.Pool, err error) {
+	if len(fields) != 3 {
+		return nil, errors.New(ErrPoolSyntax)
+	}
+
+	name := fields[0]
+	err = d.checkName(name)
+	if err != nil {
+		return nil, err
+	}
+
+	var proxies []*upstream.Proxy
+	for _, memberName := range strings.Split(fields[2], PoolMemberSeparator) {
+		if len(memberName) == 0 {
+			continue
+		}
+
+		proxy, ok := d.proxies[memberName]
+		if !ok {
+			return nil, fmt.Errorf(ErrUnknownProxy, memberName)
+		}
+
+		proxies = append(proxies, proxy)
+	}
+
+	pool, err = upstream.NewPool(name, fields[1], proxies)
+	if err != nil {
+		return nil, err
+	}
+
+	d.targets[name] = pool
+
+	return pool, nil
+}
+
+// checkName checks that the name of a proxy or a pool is not reserved and is
+// not used yet.
+func (d *declarations) checkName(name string) (err error) {
+	if strings.EqualFold(name, KeywordDirect) {
+		return fmt.Errorf(ErrReservedName, name)
+	}
+	if _, ok := d.targets[name]; ok {
+		return fmt.Errorf(ErrDuplicateName, name)
+	}
+
+	return nil
+}
+
+func (d *declarations) parseRoute(fields []string) (route *Route, err error) {
+	route = new(Route)
+
+	switch {
+	case len(fields) == 2:
+	case (len(fields) == 4) && strings.EqualFold(fields[1], KeywordFrom):
+		route.Clients, err = parseClientNetworks(fields[2])
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, errors.New(ErrRouteSyntax)
+	}
+
+	route.Pattern, err = dp.ParsePattern(fields[0])
+	if err != nil {
+		return nil, err
+	}
+
+	target := fields[len(fields)-1]
+	if strings.EqualFold(target, KeywordDirect) {
+		return route, nil
+	}
+
+	var ok bool
+	route.Pool, ok = d.targets[target]
+	if !ok {
+		return nil, fmt.Errorf(ErrUnknownProxyOrPool, target)
+	}
+	route.TargetName = target
+
+	return route, nil
+}
+
+func parseClientNetworks(s string) (list []netip.Prefix, err error) {
+	var prefix netip.Prefix
+	for _, part := range strings.Split(s, ClientNetworkSeparator) {
+		if len(part) == 0 {
+			continue
+		}
+
+		prefix, err = wm.ParsePrefix(part)
+		if err != nil {
+			return nil, fmt.Errorf(ErrClientNetworksSyntax, err)
+		}
+
+		list = append(list, prefix)
+	}
+
+	if len(list) == 0 {
+		return nil, errors.New(ErrRouteSyntax)
+	}
+
+	return list, nil
+}
+
+// Find finds the first route matching the destination and the client's IP
+// address. It returns nil when no route matches.
+func (t *Table) Find(host string, port uint16, clientIPAddress netip.Addr) (route *Route) {
+	host = dp.NormaliseHostName(host)
+	clientIPAddress = clientIPAddress.Unmap().WithZone("")
+
+	for _, route = range t.routes {
+		if route.Pattern.Match(host, port) && route.matchClient(clientIPAddress) {
+			return route
+		}
+	}
+
+	return nil
+}
+
+// Pools returns the pools of proxies declared in the table. Members of these
+// pools are health checked.
+func (t *Table) Pools() []*upstream.Pool {
+	return t.pools
+}
+
+func (r *Route) matchClient(addr netip.Addr) bool {
+	if len(r.Clients) == 0 {
+		return true
+	}
+
+	for _, prefix := range r.Clients {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// IsDirect tells whether targets of the route are connected directly.
+func (r *Route) IsDirect() bool {
+	return r.Pool == nil
+}
+
+func (r *Route) String() string {
+	var sb strings.Builder
+	sb.WriteString(r.Pattern.String())
+
+	if len(r.Clients) > 0 {
+		clients := make([]string, 0, len(r.Clients))
+		for _, prefix := range r.Clients {
+			clients = append(clients, prefix.String())
+		}
+
+		sb.WriteString(" " + KeywordFrom + " " + strings.Join(clients, ClientNetworkSeparator))
+	}
+
+	if r.IsDirect() {
+		sb.WriteString(" " + KeywordDirect)
+	} else {
+		sb.WriteString(" " + r.TargetName)
+	}
+
+	return sb.String()
+}
@@ -0,0 +1,179 @@
+package routing
+
+import (
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func newTestTable(t *testing.T, data string) (*Table, error) {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "routes.txt")
+	err := os.WriteFile(path, []byte(data), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return NewTableFromFile(path)
+}
+
+func Test_Table_Find(t *testing.T) {
+	table, err := newTestTable(t, `
+# Upstream proxies.
+proxy office http://10.0.0.1:3128
+proxy tor socks5://127.0.0.1:9050
+pool both round-robin office,tor
+
+# Routes.
+route intranet.example.com direct
+route *.example.com:443 from 192.168.1.0/24,fd00::/8 tor
+route *.example.com office
+route *.onion both
+route 10.0.0.0/8 direct
+route * office
+`)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		name           string
+		host           string
+		port           uint16
+		client         string
+		expectedTarget string
+		isDirect       bool
+	}{
+		{
+			name:     "earlier exact route wins over later wildcard",
+			host:     "Intranet.Example.com.",
+			port:     443,
+			client:   "192.168.1.5",
+			isDirect: true,
+		},
+		{
+			name:           "client network matches",
+			host:           "www.example.com",
+			port:           443,
+			client:         "192.168.1.5",
+			expectedTarget: "tor",
+		},
+		{
+			name:           "IPv4-mapped client address",
+			host:           "www.example.com",
+			port:           443,
+			client:         "::ffff:192.168.1.5",
+			expectedTarget: "tor",
+		},
+		{
+			name:           "IPv6 client network matches",
+			host:           "www.example.com",
+			port:           443,
+			client:         "fd00::5",
+			expectedTarget: "tor",
+		},
+		{
+			name:           "client network does not match",
+			host:           "www.example.com",
+			port:           443,
+			client:         "192.168.2.5",
+			expectedTarget: "office",
+		},
+		{
+			name:           "port does not match",
+			host:           "www.example.com",
+			port:           80,
+			client:         "192.168.1.5",
+			expectedTarget: "office",
+		},
+		{
+			name:           "pool",
+			host:           "abc.onion",
+			port:           80,
+			client:         "192.168.1.5",
+			expectedTarget: "both",
+		},
+		{
+			name:     "IP network",
+			host:     "10.1.2.3",
+			port:     80,
+			client:   "192.168.1.5",
+			isDirect: true,
+		},
+		{
+			name:           "catch-all route",
+			host:           "example.org",
+			port:           80,
+			client:         "192.168.1.5",
+			expectedTarget: "office",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			route := table.Find(test.host, test.port, netip.MustParseAddr(test.client))
+			if route == nil {
+				t.Fatalf("route is not found")
+			}
+			if route.IsDirect() != test.isDirect {
+				t.Errorf("route '%v' is direct: %v, expected %v", route, route.IsDirect(), test.isDirect)
+			}
+			if route.TargetName != test.expectedTarget {
+				t.Errorf("route '%v' leads to %q, expected %q", route, route.TargetName, test.expectedTarget)
+			}
+		})
+	}
+}
+
+func Test_Table_FindNoRoute(t *testing.T) {
+	table, err := newTestTable(t, "route *.example.com direct\r\nroute :443 from 10.0.0.0/8 direct\r\n")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	route := table.Find("example.org", 443, netip.MustParseAddr("192.168.1.5"))
+	if route != nil {
+		t.Errorf("route '%v' is found", route)
+	}
+}
+
+func Test_NewTableFromFile(t *testing.T) {
+	tests := []struct {
+		name            string
+		data            string
+		expectedErrLine string
+	}{
+		{name: "empty", data: ""},
+		{name: "comments", data: "# Comment.\n\n  # Indented comment.\n"},
+		{name: "unknown keyword", data: "# Comment.\nroot * direct\n", expectedErrLine: "line 2:"},
+		{name: "proxy used before declaration", data: "route * office\nproxy office http://10.0.0.1:3128\n", expectedErrLine: "line 1:"},
+		{name: "unknown pool member", data: "proxy a http://10.0.0.1:3128\npool p round-robin a,b\n", expectedErrLine: "line 2:"},
+		{name: "duplicate name", data: "proxy a http://10.0.0.1:3128\npool a round-robin a\n", expectedErrLine: "line 2:"},
+		{name: "reserved name", data: "proxy Direct http://10.0.0.1:3128\n", expectedErrLine: "line 1:"},
+		{name: "bad proxy URL", data: "proxy a ftp://10.0.0.1:21\n", expectedErrLine: "line 1:"},
+		{name: "bad route", data: "route * via direct\n", expectedErrLine: "line 1:"},
+		{name: "bad client networks", data: "route * from 10.0.0.0/33 direct\n", expectedErrLine: "line 1:"},
+		{name: "bad pattern", data: "route *.example.com:http direct\n", expectedErrLine: "line 1:"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := newTestTable(t, test.data)
+			if len(test.expectedErrLine) == 0 {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("error is expected")
+			}
+			if !strings.Contains(err.Error(), test.expectedErrLine) {
+				t.Errorf("error %q does not contain %q", err, test.expectedErrLine)
+			}
+		})
+	}
+}
@@ -0,0 +1,210 @@
+package socks
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+const (
+	ErrReply                     = "SOCKS server replied with code %d (%s)"
+	ErrNoAcceptableMethods       = "SOCKS server has not accepted authentication methods"
+	ErrUnexpectedMethod          = "SOCKS server has selected an unexpected authentication method: %d"
+	ErrUserPassAuthFailed        = "SOCKS server has rejected the user name and the password"
+	ErrUnsupportedServerVersion  = "unsupported version of SOCKS server: %v"
+	ErrUnsupportedAuthVersion    = "unsupported version of SOCKS authentication: %v"
+	ErrCredentialsAreTooLong     = "user name or password is too long"
+	ErrUnknownReplyCodeName      = "unknown error"
+	ErrCredentialsAreNotExpected = "SOCKS server requires credentials which are not set"
+)
+
+var replyNames = map[byte]string{
+	ReplyGeneralFailure:          "general failure",
+	ReplyNotAllowed:              "connection not allowed by ruleset",
+	ReplyNetworkUnreachable:      "network unreachable",
+	ReplyHostUnreachable:         "host unreachable",
+	ReplyConnectionRefused:       "connection refused",
+	ReplyTtlExpired:              "TTL expired",
+	ReplyCommandNotSupported:     "command not supported",
+	ReplyAddressTypeNotSupported: "address type not supported",
+}
+
+// ReplyError is returned by a client when a SOCKS5 server rejects its
+// request.
+type ReplyError struct {
+	Reply byte
+}
+
+func (e *ReplyError) Error() string {
+	name, ok := replyNames[e.Reply]
+	if !ok {
+		name = ErrUnknownReplyCodeName
+	}
+
+	return fmt.Sprintf(ErrReply, e.Reply, name)
+}
+
+// UserPassAuthError is returned by a client when a SOCKS5 server rejects its
+// user name and password.
+type UserPassAuthError struct{}
+
+func (e *UserPassAuthError) Error() string {
+	return ErrUserPassAuthFailed
+}
+
+// WriteGreeting writes the list of authentication methods offered by a
+// client.
+func WriteGreeting(w io.Writer, methods []byte) (err error) {
+	buf := append([]byte{Version5, byte(len(methods))}, methods...)
+	_, err = w.Write(buf)
+	return err
+}
+
+// ReadMethodSelection reads the authentication method selected by a SOCKS5
+// server.
+func ReadMethodSelection(r io.Reader) (method byte, err error) {
+	buf := make([]byte, 2)
+	_, err = io.ReadFull(r, buf)
+	if err != nil {
+		return 0, err
+	}
+	if buf[0] != Version5 {
+		return 0, fmt.Errorf(ErrUnsupportedServerVersion, buf[0])
+	}
+	if buf[1] == MethodNoAcceptable {
+		return 0, errors.New(ErrNoAcceptableMethods)
+	}
+
+	return buf[1], nil
+}
+
+// WriteUserPassAuth writes credentials of the username/password
+// authentication, RFC 1929.
+func WriteUserPassAuth(w io.Writer, userName string, password string) (err error) {
+	if (len(userName) > MaxUserPassAuthField) || (len(password) > MaxUserPassAuthField) {
+		return errors.New(ErrCredentialsAreTooLong)
+	}
+
+	buf := []byte{VersionUserPassAuth, byte(len(userName))}
+	buf = append(buf, userName...)
+	buf = append(buf, byte(len(password)))
+	buf = append(buf, password...)
+
+	_, err = w.Write(buf)
+	return err
+}
+
+// ReadUserPassAuthStatus reads the result of the username/password
+// authentication.
+func ReadUserPassAuthStatus(r io.Reader) (err error) {
+	buf := make([]byte, 2)
+	_, err = io.ReadFull(r, buf)
+	if err != nil {
+		return err
+	}
+	if buf[0] != VersionUserPassAuth {
+		return fmt.Errorf(ErrUnsupportedAuthVersion, buf[0])
+	}
+	if buf[1] != UserPassAuthStatusSuccess {
+		return &UserPassAuthError{}
+	}
+
+	return nil
+}
+
+// WriteRequest writes a SOCKS5 request. Host is either an IP address or a
+// domain name.
+func WriteRequest(w io.Writer, command byte, host string, port uint16) (err error) {
+	buf := []byte{Version5, command, 0x00}
+	buf, err = AppendAddress(buf, host, port)
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(buf)
+	return err
+}
+
+// ReadReply reads a reply to a SOCKS5 request. When the request is rejected,
+// an error of the ReplyError type is returned.
+func ReadReply(r io.Reader) (boundHost string, boundPort uint16, err error) {
+	buf := make([]byte, 3)
+	_, err = io.ReadFull(r, buf)
+	if err != nil {
+		return "", 0, err
+	}
+	if buf[0] != Version5 {
+		return "", 0, fmt.Errorf(ErrUnsupportedServerVersion, buf[0])
+	}
+
+	// The address follows the reply code even when the request is rejected.
+	boundHost, boundPort, err = ReadAddress(r)
+	if buf[1] != ReplySucceeded {
+		return "", 0, &ReplyError{Reply: buf[1]}
+	}
+	if err != nil {
+		return "", 0, err
+	}
+
+	return boundHost, boundPort, nil
+}
+
+// Connect performs the handshake of a SOCKS5 client over the connection and
+// requests a connection to the destination.
+func Connect(rw io.ReadWriter, userName string, password string, host string, port uint16) (err error) {
+	err = Authenticate(rw, userName, password)
+	if err != nil {
+		return err
+	}
+
+	err = WriteRequest(rw, CommandConnect, host, port)
+	if err != nil {
+		return err
+	}
+
+	_, _, err = ReadReply(rw)
+	return err
+}
+
+// Authenticate negotiates the authentication method with a SOCKS5 server and
+// authenticates the client. When the user name is set, the username/password
+// authentication is offered besides no authentication.
+func Authenticate(rw io.ReadWriter, userName string, password string) (err error) {
+	methods := []byte{MethodNoAuth}
+	if len(userName) > 0 {
+		methods = append(methods, MethodUserPassword)
+	}
+
+	err = WriteGreeting(rw, methods)
+	if err != nil {
+		return err
+	}
+
+	var method byte
+	method, err = ReadMethodSelection(rw)
+	if err != nil {
+		return err
+	}
+
+	switch method {
+	case MethodNoAuth:
+	case MethodUserPassword:
+		if len(userName) == 0 {
+			return errors.New(ErrCredentialsAreNotExpected)
+		}
+
+		err = WriteUserPassAuth(rw, userName, password)
+		if err != nil {
+			return err
+		}
+
+		err = ReadUserPassAuthStatus(rw)
+		if err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf(ErrUnexpectedMethod, method)
+	}
+
+	return nil
+}
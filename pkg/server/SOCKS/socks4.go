@@ -0,0 +1,105 @@
+package socks
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"net/netip"
+)
+
+const (
+	ErrStringIsTooLong = "string is too long"
+)
+
+// Version of SOCKS4 and SOCKS4a.
+const (
+	Version4 = 0x04
+
+	// Version4Reply is a version of replies to SOCKS4 requests.
+	Version4Reply = 0x00
+
+	// MaxString4Length is the maximal length of a user ID or of a domain name
+	// in SOCKS4 requests.
+	MaxString4Length = 255
+)
+
+// Commands of SOCKS4.
+const (
+	Command4Connect = 0x01
+	Command4Bind    = 0x02
+)
+
+// Reply codes of SOCKS4.
+const (
+	Reply4Granted               = 0x5A
+	Reply4Rejected              = 0x5B
+	Reply4IdentdUnreachable     = 0x5C
+	Reply4IdentdUserIdsMismatch = 0x5D
+)
+
+// ReadRequest4 reads a SOCKS4 or a SOCKS4a request. The version byte must be
+// already read by the caller. In SOCKS4a requests, the destination is a
+// domain name which is resolved by the server.
+func ReadRequest4(r io.Reader) (req *Request, userID string, err error) {
+	buf := make([]byte, 7)
+	_, err = io.ReadFull(r, buf)
+	if err != nil {
+		return nil, "", err
+	}
+
+	req = &Request{
+		Command: buf[0],
+		Port:    binary.BigEndian.Uint16(buf[1:3]),
+	}
+
+	ip := netip.AddrFrom4([4]byte(buf[3:7]))
+
+	userID, err = readNullTerminatedString(r)
+	if err != nil {
+		return nil, "", err
+	}
+
+	// SOCKS4a uses addresses of the '0.0.0.x' form, where 'x' is not zero.
+	if (buf[3] == 0) && (buf[4] == 0) && (buf[5] == 0) && (buf[6] != 0) {
+		req.Host, err = readNullTerminatedString(r)
+		if err != nil {
+			return nil, "", err
+		}
+		if len(req.Host) == 0 {
+			return nil, "", errors.New(ErrDomainNameIsEmpty)
+		}
+
+		return req, userID, nil
+	}
+
+	req.Host = ip.String()
+
+	return req, userID, nil
+}
+
+// WriteReply4 writes a reply to a SOCKS4 request. Address in the reply is
+// not used by clients of the CONNECT command and is set to zero.
+func WriteReply4(w io.Writer, reply byte) (err error) {
+	_, err = w.Write([]byte{Version4Reply, reply, 0, 0, 0, 0, 0, 0})
+	return err
+}
+
+func readNullTerminatedString(r io.Reader) (s string, err error) {
+	buf := make([]byte, 0, 32)
+
+	var b byte
+	for {
+		b, err = readByte(r)
+		if err != nil {
+			return "", err
+		}
+		if b == 0 {
+			return string(buf), nil
+		}
+		if len(buf) >= MaxString4Length {
+			return "", errors.New(ErrStringIsTooLong)
+		}
+
+		buf = append(buf, b)
+	}
+}
@@ -0,0 +1,121 @@
+package socks
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func Test_ReadRequest4(t *testing.T) {
+	longString := strings.Repeat("a", MaxString4Length)
+
+	tests := []struct {
+		name            string
+		data            []byte
+		expectedRequest Request
+		expectedUserID  string
+		isErr           bool
+	}{
+		{
+			name:            "SOCKS4 CONNECT",
+			data:            []byte("\x01\x01\xBB\xC0\xA8\x00\x01alice\x00"),
+			expectedRequest: Request{Command: Command4Connect, Host: "192.168.0.1", Port: 443},
+			expectedUserID:  "alice",
+		},
+		{
+			name:            "SOCKS4 CONNECT without user ID",
+			data:            []byte("\x01\x00\x50\x0A\x00\x00\x01\x00"),
+			expectedRequest: Request{Command: Command4Connect, Host: "10.0.0.1", Port: 80},
+		},
+		{
+			name:            "SOCKS4a CONNECT",
+			data:            []byte("\x01\x01\xBB\x00\x00\x00\x01alice\x00example.com\x00"),
+			expectedRequest: Request{Command: Command4Connect, Host: "example.com", Port: 443},
+			expectedUserID:  "alice",
+		},
+		{
+			name:            "SOCKS4 zero address",
+			data:            []byte("\x01\x01\xBB\x00\x00\x00\x00\x00"),
+			expectedRequest: Request{Command: Command4Connect, Host: "0.0.0.0", Port: 443},
+		},
+		{
+			name:            "user ID and domain of maximal length",
+			data:            []byte("\x01\x01\xBB\x00\x00\x00\x01" + longString + "\x00" + longString + "\x00"),
+			expectedRequest: Request{Command: Command4Connect, Host: longString, Port: 443},
+			expectedUserID:  longString,
+		},
+		{
+			// Commands are checked by the server, which replies to unknown
+			// ones.
+			name:            "BIND",
+			data:            []byte("\x02\x01\xBB\xC0\xA8\x00\x01\x00"),
+			expectedRequest: Request{Command: Command4Bind, Host: "192.168.0.1", Port: 443},
+		},
+		{
+			name:            "unknown command",
+			data:            []byte("\x09\x01\xBB\xC0\xA8\x00\x01\x00"),
+			expectedRequest: Request{Command: 0x09, Host: "192.168.0.1", Port: 443},
+		},
+		{
+			name:  "truncated header",
+			data:  []byte("\x01\x01\xBB\xC0\xA8"),
+			isErr: true,
+		},
+		{
+			name:  "unterminated user ID",
+			data:  []byte("\x01\x01\xBB\xC0\xA8\x00\x01alice"),
+			isErr: true,
+		},
+		{
+			name:  "oversized user ID",
+			data:  []byte("\x01\x01\xBB\xC0\xA8\x00\x01" + longString + "a\x00"),
+			isErr: true,
+		},
+		{
+			name:  "SOCKS4a missing domain",
+			data:  []byte("\x01\x01\xBB\x00\x00\x00\x01alice\x00"),
+			isErr: true,
+		},
+		{
+			name:  "SOCKS4a unterminated domain",
+			data:  []byte("\x01\x01\xBB\x00\x00\x00\x01alice\x00example.com"),
+			isErr: true,
+		},
+		{
+			name:  "SOCKS4a empty domain",
+			data:  []byte("\x01\x01\xBB\x00\x00\x00\x01alice\x00\x00"),
+			isErr: true,
+		},
+		{
+			name:  "SOCKS4a oversized domain",
+			data:  []byte("\x01\x01\xBB\x00\x00\x00\x01\x00" + longString + "a\x00"),
+			isErr: true,
+		},
+		{
+			name:  "empty",
+			data:  nil,
+			isErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req, userID, err := ReadRequest4(bytes.NewReader(test.data))
+			if test.isErr {
+				if err == nil {
+					t.Fatalf("error is expected, request is %+v", req)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if *req != test.expectedRequest {
+				t.Errorf("request is %+v, expected %+v", *req, test.expectedRequest)
+			}
+			if userID != test.expectedUserID {
+				t.Errorf("user ID is %q, expected %q", userID, test.expectedUserID)
+			}
+		})
+	}
+}
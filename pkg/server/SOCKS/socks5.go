@@ -0,0 +1,300 @@
+package socks
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"strconv"
+)
+
+const (
+	ErrUnsupportedVersion     = "unsupported SOCKS version: %v"
+	ErrUnsupportedAddressType = "unsupported address type: %v"
+	ErrNoMethods              = "no authentication methods offered"
+	ErrDomainNameIsEmpty      = "domain name is empty"
+	ErrDomainNameIsTooLong    = "domain name is too long"
+	ErrUserNameIsTooLong      = "user name is too long"
+	ErrPasswordIsTooLong      = "password is too long"
+)
+
+// Versions.
+const (
+	Version5             = 0x05
+	VersionUserPassAuth  = 0x01
+	MaxUserPassAuthField = 255
+	MaxDomainNameLength  = 255
+)
+
+// Authentication methods, RFC 1928.
+const (
+	MethodNoAuth       = 0x00
+	MethodUserPassword = 0x02
+	MethodNoAcceptable = 0xFF
+)
+
+// Commands.
+const (
+	CommandConnect      = 0x01
+	CommandBind         = 0x02
+	CommandUdpAssociate = 0x03
+)
+
+// Address types.
+const (
+	AddressTypeIPv4   = 0x01
+	AddressTypeDomain = 0x03
+	AddressTypeIPv6   = 0x04
+)
+
+// Reply codes.
+const (
+	ReplySucceeded               = 0x00
+	ReplyGeneralFailure          = 0x01
+	ReplyNotAllowed              = 0x02
+	ReplyNetworkUnreachable      = 0x03
+	ReplyHostUnreachable         = 0x04
+	ReplyConnectionRefused       = 0x05
+	ReplyTtlExpired              = 0x06
+	ReplyCommandNotSupported     = 0x07
+	ReplyAddressTypeNotSupported = 0x08
+)
+
+// Statuses of the username/password authentication, RFC 1929.
+const (
+	UserPassAuthStatusSuccess = 0x00
+	UserPassAuthStatusFailure = 0x01
+)
+
+// Request is a request of a SOCKS client.
+type Request struct {
+	Command byte
+
+	// Host is either an IP address or a domain name.
+	Host string
+	Port uint16
+}
+
+// Address returns the destination in the 'host:port' form.
+func (r *Request) Address() string {
+	return net.JoinHostPort(r.Host, strconv.Itoa(int(r.Port)))
+}
+
+// ReadGreeting reads the list of authentication methods offered by a SOCKS5
+// client. The version byte must be already read by the caller.
+func ReadGreeting(r io.Reader) (methods []byte, err error) {
+	var n byte
+	n, err = readByte(r)
+	if err != nil {
+		return nil, err
+	}
+	if n == 0 {
+		return nil, errors.New(ErrNoMethods)
+	}
+
+	methods = make([]byte, n)
+	_, err = io.ReadFull(r, methods)
+	if err != nil {
+		return nil, err
+	}
+
+	return methods, nil
+}
+
+// WriteMethodSelection writes the authentication method selected by the
+// server.
+func WriteMethodSelection(w io.Writer, method byte) (err error) {
+	_, err = w.Write([]byte{Version5, method})
+	return err
+}
+
+// ReadUserPassAuth reads credentials of the username/password
+// authentication, RFC 1929.
+func ReadUserPassAuth(r io.Reader) (userName string, password string, err error) {
+	var version byte
+	version, err = readByte(r)
+	if err != nil {
+		return "", "", err
+	}
+	if version != VersionUserPassAuth {
+		return "", "", fmt.Errorf(ErrUnsupportedVersion, version)
+	}
+
+	userName, err = readString(r)
+	if err != nil {
+		return "", "", err
+	}
+
+	password, err = readString(r)
+	if err != nil {
+		return "", "", err
+	}
+
+	return userName, password, nil
+}
+
+// WriteUserPassAuthStatus writes the result of the username/password
+// authentication.
+func WriteUserPassAuthStatus(w io.Writer, isSuccess bool) (err error) {
+	status := byte(UserPassAuthStatusFailure)
+	if isSuccess {
+		status = UserPassAuthStatusSuccess
+	}
+
+	_, err = w.Write([]byte{VersionUserPassAuth, status})
+	return err
+}
+
+// ReadRequest reads a SOCKS5 request.
+func ReadRequest(r io.Reader) (req *Request, err error) {
+	header := make([]byte, 3)
+	_, err = io.ReadFull(r, header)
+	if err != nil {
+		return nil, err
+	}
+	if header[0] != Version5 {
+		return nil, fmt.Errorf(ErrUnsupportedVersion, header[0])
+	}
+
+	req = &Request{
+		Command: header[1],
+	}
+
+	req.Host, req.Port, err = ReadAddress(r)
+	if err != nil {
+		return nil, err
+	}
+
+	return req, nil
+}
+
+// ReadAddress reads an address in the SOCKS5 format: an address type, an
+// address and a port.
+func ReadAddress(r io.Reader) (host string, port uint16, err error) {
+	var addressType byte
+	addressType, err = readByte(r)
+	if err != nil {
+		return "", 0, err
+	}
+
+	switch addressType {
+	case AddressTypeIPv4:
+		buf := make([]byte, 4)
+		_, err = io.ReadFull(r, buf)
+		if err != nil {
+			return "", 0, err
+		}
+		host = netip.AddrFrom4([4]byte(buf)).String()
+
+	case AddressTypeIPv6:
+		buf := make([]byte, 16)
+		_, err = io.ReadFull(r, buf)
+		if err != nil {
+			return "", 0, err
+		}
+		host = netip.AddrFrom16([16]byte(buf)).Unmap().String()
+
+	case AddressTypeDomain:
+		host, err = readString(r)
+		if err != nil {
+			return "", 0, err
+		}
+		if len(host) == 0 {
+			return "", 0, errors.New(ErrDomainNameIsEmpty)
+		}
+
+	default:
+		return "", 0, &AddressTypeError{AddressType: addressType}
+	}
+
+	buf := make([]byte, 2)
+	_, err = io.ReadFull(r, buf)
+	if err != nil {
+		return "", 0, err
+	}
+
+	return host, binary.BigEndian.Uint16(buf), nil
+}
+
+// AppendAddress appends an address in the SOCKS5 format to the buffer.
+// Host is either an IP address or a domain name.
+func AppendAddress(buf []byte, host string, port uint16) ([]byte, error) {
+	addr, err := netip.ParseAddr(host)
+	switch {
+	case (err == nil) && addr.Unmap().Is4():
+		buf = append(buf, AddressTypeIPv4)
+		buf = append(buf, addr.Unmap().AsSlice()...)
+
+	case err == nil:
+		buf = append(buf, AddressTypeIPv6)
+		buf = append(buf, addr.AsSlice()...)
+
+	default:
+		if len(host) > MaxDomainNameLength {
+			return nil, errors.New(ErrDomainNameIsTooLong)
+		}
+		buf = append(buf, AddressTypeDomain, byte(len(host)))
+		buf = append(buf, host...)
+	}
+
+	return binary.BigEndian.AppendUint16(buf, port), nil
+}
+
+// WriteReply writes a reply to a SOCKS5 request. The bound address may be
+// nil, then a zero IPv4 address is sent.
+func WriteReply(w io.Writer, reply byte, boundAddr net.Addr) (err error) {
+	host, port := "0.0.0.0", uint16(0)
+	if boundAddr != nil {
+		addrPort, perr := netip.ParseAddrPort(boundAddr.String())
+		if perr == nil {
+			host, port = addrPort.Addr().Unmap().String(), addrPort.Port()
+		}
+	}
+
+	buf := []byte{Version5, reply, 0x00}
+	buf, err = AppendAddress(buf, host, port)
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(buf)
+	return err
+}
+
+// AddressTypeError is returned when a client uses an unknown address type.
+type AddressTypeError struct {
+	AddressType byte
+}
+
+func (e *AddressTypeError) Error() string {
+	return fmt.Sprintf(ErrUnsupportedAddressType, e.AddressType)
+}
+
+func readByte(r io.Reader) (b byte, err error) {
+	buf := make([]byte, 1)
+	_, err = io.ReadFull(r, buf)
+	if err != nil {
+		return 0, err
+	}
+
+	return buf[0], nil
+}
+
+// readString reads a string prefixed with its length of one byte.
+func readString(r io.Reader) (s string, err error) {
+	var n byte
+	n, err = readByte(r)
+	if err != nil {
+		return "", err
+	}
+
+	buf := make([]byte, n)
+	_, err = io.ReadFull(r, buf)
+	if err != nil {
+		return "", err
+	}
+
+	return string(buf), nil
+}
@@ -0,0 +1,286 @@
+package socks
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func Test_ReadGreeting(t *testing.T) {
+	tests := []struct {
+		name            string
+		data            []byte
+		expectedMethods []byte
+		isErr           bool
+	}{
+		{
+			name:            "single method",
+			data:            []byte{1, MethodNoAuth},
+			expectedMethods: []byte{MethodNoAuth},
+		},
+		{
+			name:            "several methods",
+			data:            []byte{3, MethodNoAuth, MethodUserPassword, 0x80},
+			expectedMethods: []byte{MethodNoAuth, MethodUserPassword, 0x80},
+		},
+		{
+			name:  "no methods",
+			data:  []byte{0},
+			isErr: true,
+		},
+		{
+			name:  "truncated methods",
+			data:  []byte{3, MethodNoAuth},
+			isErr: true,
+		},
+		{
+			name:  "oversized number of methods",
+			data:  append([]byte{255}, make([]byte, 254)...),
+			isErr: true,
+		},
+		{
+			name:  "empty",
+			data:  nil,
+			isErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			methods, err := ReadGreeting(bytes.NewReader(test.data))
+			if test.isErr {
+				if err == nil {
+					t.Fatalf("error is expected, methods are %v", methods)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !bytes.Equal(methods, test.expectedMethods) {
+				t.Errorf("methods are %v, expected %v", methods, test.expectedMethods)
+			}
+		})
+	}
+}
+
+func Test_ReadUserPassAuth(t *testing.T) {
+	tests := []struct {
+		name             string
+		data             []byte
+		expectedUserName string
+		expectedPassword string
+		isErr            bool
+	}{
+		{
+			name:             "valid",
+			data:             []byte("\x01\x05alice\x06secret"),
+			expectedUserName: "alice",
+			expectedPassword: "secret",
+		},
+		{
+			name:             "empty password",
+			data:             []byte("\x01\x05alice\x00"),
+			expectedUserName: "alice",
+		},
+		{
+			name:  "unsupported version",
+			data:  []byte("\x05\x05alice\x06secret"),
+			isErr: true,
+		},
+		{
+			name:  "truncated user name",
+			data:  []byte("\x01\x05ali"),
+			isErr: true,
+		},
+		{
+			name:  "missing password",
+			data:  []byte("\x01\x05alice"),
+			isErr: true,
+		},
+		{
+			name:  "oversized password length",
+			data:  []byte("\x01\x05alice\xFFsecret"),
+			isErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			userName, password, err := ReadUserPassAuth(bytes.NewReader(test.data))
+			if test.isErr {
+				if err == nil {
+					t.Fatal("error is expected")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if (userName != test.expectedUserName) || (password != test.expectedPassword) {
+				t.Errorf("credentials are %q:%q, expected %q:%q",
+					userName, password, test.expectedUserName, test.expectedPassword)
+			}
+		})
+	}
+}
+
+func Test_ReadRequest(t *testing.T) {
+	longName := strings.Repeat("a", MaxDomainNameLength)
+
+	tests := []struct {
+		name             string
+		data             []byte
+		expectedRequest  Request
+		isErr            bool
+		isAddressTypeErr bool
+	}{
+		{
+			name:            "CONNECT IPv4",
+			data:            []byte{Version5, CommandConnect, 0, AddressTypeIPv4, 192, 168, 0, 1, 0x01, 0xBB},
+			expectedRequest: Request{Command: CommandConnect, Host: "192.168.0.1", Port: 443},
+		},
+		{
+			name: "CONNECT IPv6",
+			data: []byte{Version5, CommandConnect, 0, AddressTypeIPv6,
+				0x20, 0x01, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0x01, 0x00, 0x50},
+			expectedRequest: Request{Command: CommandConnect, Host: "2001::1", Port: 80},
+		},
+		{
+			name: "CONNECT IPv4-mapped IPv6",
+			data: []byte{Version5, CommandConnect, 0, AddressTypeIPv6,
+				0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0xFF, 0xFF, 10, 0, 0, 1, 0x00, 0x50},
+			expectedRequest: Request{Command: CommandConnect, Host: "10.0.0.1", Port: 80},
+		},
+		{
+			name:            "CONNECT domain",
+			data:            append([]byte{Version5, CommandConnect, 0, AddressTypeDomain, 11}, "example.com\x01\xBB"...),
+			expectedRequest: Request{Command: CommandConnect, Host: "example.com", Port: 443},
+		},
+		{
+			name:            "CONNECT domain of maximal length",
+			data:            append(append([]byte{Version5, CommandConnect, 0, AddressTypeDomain, MaxDomainNameLength}, longName...), 0x00, 0x50),
+			expectedRequest: Request{Command: CommandConnect, Host: longName, Port: 80},
+		},
+		{
+			name:            "UDP ASSOCIATE",
+			data:            []byte{Version5, CommandUdpAssociate, 0, AddressTypeIPv4, 0, 0, 0, 0, 0, 0},
+			expectedRequest: Request{Command: CommandUdpAssociate, Host: "0.0.0.0", Port: 0},
+		},
+		{
+			// Commands are checked by the server, which replies to unknown
+			// ones.
+			name:            "unknown command",
+			data:            []byte{Version5, 0x09, 0, AddressTypeIPv4, 192, 168, 0, 1, 0x01, 0xBB},
+			expectedRequest: Request{Command: 0x09, Host: "192.168.0.1", Port: 443},
+		},
+		{
+			name:  "unsupported version",
+			data:  []byte{Version4, CommandConnect, 0, AddressTypeIPv4, 192, 168, 0, 1, 0x01, 0xBB},
+			isErr: true,
+		},
+		{
+			name:             "unknown address type",
+			data:             []byte{Version5, CommandConnect, 0, 0x02, 192, 168, 0, 1, 0x01, 0xBB},
+			isErr:            true,
+			isAddressTypeErr: true,
+		},
+		{
+			name:  "empty domain",
+			data:  []byte{Version5, CommandConnect, 0, AddressTypeDomain, 0, 0x01, 0xBB},
+			isErr: true,
+		},
+		{
+			name:  "oversized domain length",
+			data:  append([]byte{Version5, CommandConnect, 0, AddressTypeDomain, 200}, "example.com\x01\xBB"...),
+			isErr: true,
+		},
+		{
+			name:  "truncated header",
+			data:  []byte{Version5, CommandConnect},
+			isErr: true,
+		},
+		{
+			name:  "missing address type",
+			data:  []byte{Version5, CommandConnect, 0},
+			isErr: true,
+		},
+		{
+			name:  "truncated IPv4 address",
+			data:  []byte{Version5, CommandConnect, 0, AddressTypeIPv4, 192, 168},
+			isErr: true,
+		},
+		{
+			name:  "truncated IPv6 address",
+			data:  []byte{Version5, CommandConnect, 0, AddressTypeIPv6, 0x20, 0x01, 0, 0},
+			isErr: true,
+		},
+		{
+			name:  "truncated port",
+			data:  []byte{Version5, CommandConnect, 0, AddressTypeIPv4, 192, 168, 0, 1, 0x01},
+			isErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req, err := ReadRequest(bytes.NewReader(test.data))
+			if test.isErr {
+				if err == nil {
+					t.Fatalf("error is expected, request is %+v", req)
+				}
+				var ate *AddressTypeError
+				if errors.As(err, &ate) != test.isAddressTypeErr {
+					t.Errorf("unexpected type of error: %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if *req != test.expectedRequest {
+				t.Errorf("request is %+v, expected %+v", *req, test.expectedRequest)
+			}
+		})
+	}
+}
+
+func Test_AppendAddress(t *testing.T) {
+	tests := []struct {
+		name         string
+		host         string
+		port         uint16
+		expectedHost string
+		isErr        bool
+	}{
+		{name: "IPv4", host: "192.168.0.1", port: 443, expectedHost: "192.168.0.1"},
+		{name: "IPv6", host: "2001::1", port: 80, expectedHost: "2001::1"},
+		{name: "IPv4-mapped IPv6", host: "::ffff:10.0.0.1", port: 80, expectedHost: "10.0.0.1"},
+		{name: "domain", host: "example.com", port: 8080, expectedHost: "example.com"},
+		{name: "oversized domain", host: strings.Repeat("a", MaxDomainNameLength+1), port: 80, isErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			buf, err := AppendAddress(nil, test.host, test.port)
+			if test.isErr {
+				if err == nil {
+					t.Fatalf("error is expected, address is %v", buf)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			host, port, err := ReadAddress(bytes.NewReader(buf))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if (host != test.expectedHost) || (port != test.port) {
+				t.Errorf("address is %s:%d, expected %s:%d", host, port, test.expectedHost, test.port)
+			}
+		})
+	}
+}
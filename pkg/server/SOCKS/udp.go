@@ -0,0 +1,49 @@
+package socks
+
+import (
+	"bytes"
+	"errors"
+)
+
+const (
+	ErrUdpDatagramIsTooShort      = "UDP datagram is too short"
+	ErrUdpFragmentationNotAllowed = "UDP fragmentation is not supported"
+)
+
+// UdpHeaderReservedSize is the size of the reserved field of a UDP datagram's
+// header.
+const UdpHeaderReservedSize = 2
+
+// ParseUdpDatagram parses a UDP datagram of a SOCKS5 client. Fragmented
+// datagrams are not supported.
+func ParseUdpDatagram(datagram []byte) (host string, port uint16, data []byte, err error) {
+	if len(datagram) < UdpHeaderReservedSize+1 {
+		return "", 0, nil, errors.New(ErrUdpDatagramIsTooShort)
+	}
+
+	if datagram[UdpHeaderReservedSize] != 0 {
+		return "", 0, nil, errors.New(ErrUdpFragmentationNotAllowed)
+	}
+
+	r := bytes.NewReader(datagram[UdpHeaderReservedSize+1:])
+	host, port, err = ReadAddress(r)
+	if err != nil {
+		return "", 0, nil, err
+	}
+
+	return host, port, datagram[len(datagram)-r.Len():], nil
+}
+
+// AppendUdpDatagram appends a UDP datagram for a SOCKS5 client to the buffer.
+// Host and port are the address of the datagram's source.
+func AppendUdpDatagram(buf []byte, host string, port uint16, data []byte) ([]byte, error) {
+	buf = append(buf, make([]byte, UdpHeaderReservedSize+1)...)
+
+	var err error
+	buf, err = AppendAddress(buf, host, port)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(buf, data...), nil
+}
@@ -2,23 +2,46 @@ package server
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"log"
 	"net"
 	"net/http"
-	"strconv"
+	"os"
 	"sync"
 	"sync/atomic"
 	"time"
 
 	zlog "github.com/rs/zerolog/log"
+
+	cl "github.com/vault-thirteen/Forward-Proxy/pkg/server/ConnectionLimiter"
+	rl "github.com/vault-thirteen/Forward-Proxy/pkg/server/RateLimiter"
 )
 
 type Server struct {
 	parameters *Parameters
 
-	// HTTP(S) server.
-	listenDsn  string
-	httpServer *http.Server
+	// HTTP(S) listeners.
+	listeners []*listener
+
+	// Listener of SOCKS clients. It is nil when SOCKS is disabled.
+	socksListener *listener
+
+	// Counters of SOCKS UDP associations. Bytes are counted when an
+	// association is closed.
+	socksUdpAssociations  atomic.Int64
+	socksUdpBytesSent     atomic.Uint64
+	socksUdpBytesReceived atomic.Uint64
+
+	// Monitoring HTTP server. It is nil when monitoring is disabled.
+	statsServer   *http.Server
+	statsListener net.Listener
+
+	// Limiter of request rate. It is nil when requests are not limited.
+	rateLimiter *rl.RateLimiter
+
+	// Counter and limiter of simultaneous requests and tunnels.
+	connLimiter *cl.ConnectionLimiter
 
 	// Channel for an external controller. When a message comes from this
 	// channel, a controller must stop this server. The server does not stop
@@ -29,65 +52,273 @@ type Server struct {
 	subRoutines *sync.WaitGroup
 	mustStop    *atomic.Bool
 	httpErrors  chan error
+	quit        chan struct{}
 }
 
 func NewServer(p *Parameters) (srv *Server, err error) {
 	srv = &Server{
 		parameters:    p,
-		listenDsn:     net.JoinHostPort(p.Host, strconv.Itoa(int(p.Port))),
-		httpServer:    nil, // See below.
 		mustBeStopped: make(chan bool, 2),
 		subRoutines:   new(sync.WaitGroup),
 		mustStop:      new(atomic.Bool),
 		httpErrors:    make(chan error, 8),
+		quit:          make(chan struct{}),
+	}
+
+	if p.RequestRateLimit > 0 {
+		srv.rateLimiter = rl.New(p.RequestRateLimit, int(p.RequestRateBurst))
+	}
+
+	srv.connLimiter = cl.New(int(p.MaxConnections), int(p.MaxConnectionsPerClient))
+
+	err = srv.createListeners()
+	if err != nil {
+		return nil, err
 	}
 
-	srv.httpServer = &http.Server{
-		Addr:    srv.listenDsn,
-		Handler: http.HandlerFunc(srv.router),
+	if len(p.StatisticsListenDsn) > 0 {
+		srv.statsServer = &http.Server{
+			Addr:    p.StatisticsListenDsn,
+			Handler: http.HandlerFunc(srv.statisticsHandler),
+		}
 	}
 
 	return srv, nil
 }
 
+// GetListenDsn returns the address of the first HTTP listener.
 func (s *Server) GetListenDsn() (dsn string) {
-	return s.listenDsn
+	return s.listeners[0].String()
+}
+
+// GetListenDsns returns the addresses of all the HTTP listeners.
+func (s *Server) GetListenDsns() (dsns []string) {
+	dsns = make([]string, 0, len(s.listeners))
+	for _, ls := range s.listeners {
+		dsns = append(dsns, ls.String())
+	}
+	return dsns
+}
+
+// GetSocksListenDsn returns the listen address of SOCKS clients. It is empty
+// when SOCKS is disabled.
+func (s *Server) GetSocksListenDsn() (dsn string) {
+	if s.socksListener == nil {
+		return ""
+	}
+	return s.socksListener.String()
+}
+
+// GetParentProxy returns the URL of the parent proxy without the password.
+// It is empty when targets are connected directly.
+func (s *Server) GetParentProxy() (parent string) {
+	if s.parameters.parentProxy == nil {
+		return ""
+	}
+	return s.parameters.parentProxy.String()
 }
 
 func (s *Server) GetStopChannel() *chan bool {
 	return &s.mustBeStopped
 }
 
+// Start opens all the listeners, drops privileges when it is configured, and
+// then starts serving clients. Privileges are dropped after the listeners are
+// opened, so that privileged ports may be used.
 func (s *Server) Start() (err error) {
-	s.startHttpServer()
+	err = s.openListeners()
+	if err != nil {
+		return errors.Join(err, s.closeListeners())
+	}
+
+	if s.mustDropPrivileges() {
+		err = dropPrivileges(s.parameters.RunAsUser, s.parameters.RunAsGroup)
+		if err != nil {
+			return errors.Join(err, s.closeListeners())
+		}
+
+		log.Println(fmt.Sprintf("Privileges have been dropped: uid %d, gid %d.", os.Getuid(), os.Getgid()))
+	}
+
+	for _, ls := range s.listeners {
+		s.startHttpServer(ls)
+	}
+
+	if s.socksListener != nil {
+		s.startSocksServer()
+	}
+
+	if s.statsServer != nil {
+		s.startStatsServer()
+	}
 
 	s.subRoutines.Add(1)
 	go s.listenForHttpErrors()
 
+	if s.usesLists() && (s.parameters.WorkModeListCheckPeriod > 0) {
+		s.subRoutines.Add(1)
+		go s.watchListFile()
+	}
+
+	if s.rateLimiter != nil {
+		s.subRoutines.Add(1)
+		go s.cleanRateLimiter()
+	}
+
+	if s.usesPools() {
+		s.subRoutines.Add(1)
+		go s.checkPoolMembers()
+	}
+
+	if (s.parameters.tlsCertificate != nil) && (s.parameters.TlsCertCheckPeriod > 0) {
+		s.subRoutines.Add(1)
+		go s.watchCertificateFiles()
+	}
+
 	return nil
 }
 
+// Stop closes all the listeners and waits for the subroutines. Servers which
+// have not finished their requests in time are closed forcibly. All the
+// listeners are closed even when some of them fail, errors are joined.
 func (s *Server) Stop() (err error) {
 	s.mustStop.Store(true)
+	close(s.quit)
 
 	ctx, cf := context.WithTimeout(context.Background(), time.Minute)
 	defer cf()
-	err = s.httpServer.Shutdown(ctx)
-	if err != nil {
-		return err
+
+	var errs []error
+	for _, ls := range s.listeners {
+		errs = append(errs, shutdownHttpServer(ctx, ls.httpServer))
+	}
+
+	if s.socksListener != nil {
+		errs = append(errs, s.socksListener.netListener.Close())
+	}
+
+	if s.statsServer != nil {
+		errs = append(errs, shutdownHttpServer(ctx, s.statsServer))
 	}
 
 	close(s.httpErrors)
 
 	s.subRoutines.Wait()
 
+	return errors.Join(errs...)
+}
+
+// shutdownHttpServer shuts the HTTP server down gracefully. When the context
+// ends before the requests are finished, the server is closed forcibly.
+func shutdownHttpServer(ctx context.Context, srv *http.Server) (err error) {
+	err = srv.Shutdown(ctx)
+	if err != nil {
+		return errors.Join(err, srv.Close())
+	}
+
+	return nil
+}
+
+// closeListeners closes the network listeners which have been opened by a
+// failed start of the server.
+func (s *Server) closeListeners() (err error) {
+	var errs []error
+	for _, ls := range s.listeners {
+		if ls.netListener != nil {
+			errs = append(errs, ls.netListener.Close())
+		}
+	}
+
+	if (s.socksListener != nil) && (s.socksListener.netListener != nil) {
+		errs = append(errs, s.socksListener.netListener.Close())
+	}
+
+	if s.statsListener != nil {
+		errs = append(errs, s.statsListener.Close())
+	}
+
+	return errors.Join(errs...)
+}
+
+// newHttpListener creates an HTTP listener. Every HTTP listener has its own
+// HTTP server, which passes requests to the router together with the
+// listener.
+func (s *Server) newHttpListener(la *listenAddress, inherited net.Listener) (ls *listener) {
+	ls = s.newListener(la, inherited)
+
+	ls.httpServer = &http.Server{
+		Addr: la.Address,
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			s.router(w, req, ls)
+		}),
+	}
+
+	// The TLS configuration is cloned, because HTTP servers modify it to
+	// announce their protocols.
+	if s.parameters.tlsConfig != nil {
+		ls.httpServer.TLSConfig = s.parameters.tlsConfig.Clone()
+	}
+
+	ls.httpServer.Protocols = newHttpProtocols(s.parameters)
+
+	return ls
+}
+
+// openListeners opens the network listeners of all the servers.
+func (s *Server) openListeners() (err error) {
+	for _, ls := range s.listeners {
+		err = ls.open()
+		if err != nil {
+			return err
+		}
+	}
+
+	if s.socksListener != nil {
+		err = s.socksListener.open()
+		if err != nil {
+			return err
+		}
+	}
+
+	if s.statsServer != nil {
+		s.statsListener, err = net.Listen("tcp", s.statsServer.Addr)
+		if err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
-func (s *Server) startHttpServer() {
+func (s *Server) mustDropPrivileges() bool {
+	return (len(s.parameters.RunAsUser) > 0) || (len(s.parameters.RunAsGroup) > 0)
+}
+
+func (s *Server) startHttpServer(ls *listener) {
+	netListener := ls.netListener
+
+	// Load balancers connect through TCP.
+	if s.parameters.MustUseProxyProtocol && (ls.network() == ListenNetworkTcp) {
+		netListener = s.newProxyProtocolListener(netListener)
+	}
+
+	go func() {
+		var listenError error
+		if ls.httpServer.TLSConfig != nil {
+			listenError = ls.httpServer.ServeTLS(netListener, "", "")
+		} else {
+			listenError = ls.httpServer.Serve(netListener)
+		}
+		if (listenError != nil) && (listenError != http.ErrServerClosed) {
+			s.httpErrors <- listenError
+		}
+	}()
+}
+
+func (s *Server) startStatsServer() {
 	go func() {
 		var listenError error
-		listenError = s.httpServer.ListenAndServe()
+		listenError = s.statsServer.Serve(s.statsListener)
 		if (listenError != nil) && (listenError != http.ErrServerClosed) {
 			s.httpErrors <- listenError
 		}
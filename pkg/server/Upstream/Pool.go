@@ -0,0 +1,225 @@
+package upstream
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"sync/atomic"
+
+	socks "github.com/vault-thirteen/Forward-Proxy/pkg/server/SOCKS"
+)
+
+const (
+	ErrPoolIsEmpty        = "pool has no members"
+	ErrUnknownBalancing   = "unknown balancing method: %v"
+	ErrNoHealthyMembers   = "pool '%v' has no healthy members"
+	ErrDuplicatePoolProxy = "proxy is used twice in pool: %v"
+)
+
+// Balancing methods.
+const (
+	BalancingRoundRobin       = "round-robin"
+	BalancingLeastConnections = "least-connections"
+)
+
+// Pool is a group of upstream proxies used by a route. Members of a checked
+// pool are ejected when they fail and are reinstated when a health check
+// succeeds. A pool of a single proxy is not checked, its proxy is always
+// used.
+type Pool struct {
+	name      string
+	balancing string
+	isChecked bool
+	members   []*Member
+	next      atomic.Uint64
+}
+
+// Member is an upstream proxy of a pool.
+type Member struct {
+	Proxy *Proxy
+
+	isEjected   atomic.Bool
+	connections atomic.Int64
+
+	// isHandshakeRequired is set when the member has been ejected for a
+	// rejection of the credentials. Such a member is not reinstated by a
+	// check which only connects to it.
+	isHandshakeRequired atomic.Bool
+}
+
+// NewPool creates a checked pool of upstream proxies. All the members are
+// healthy at the start.
+func NewPool(name string, balancing string, proxies []*Proxy) (p *Pool, err error) {
+	if len(proxies) == 0 {
+		return nil, errors.New(ErrPoolIsEmpty)
+	}
+
+	balancing = strings.ToLower(balancing)
+	switch balancing {
+	case BalancingRoundRobin,
+		BalancingLeastConnections:
+	default:
+		return nil, fmt.Errorf(ErrUnknownBalancing, balancing)
+	}
+
+	p = &Pool{
+		name:      name,
+		balancing: balancing,
+		isChecked: true,
+		members:   make([]*Member, 0, len(proxies)),
+	}
+
+	for _, proxy := range proxies {
+		if slices.ContainsFunc(p.members, func(m *Member) bool { return m.Proxy == proxy }) {
+			return nil, fmt.Errorf(ErrDuplicatePoolProxy, proxy)
+		}
+
+		p.members = append(p.members, &Member{Proxy: proxy})
+	}
+
+	return p, nil
+}
+
+// NewSingleProxyPool creates a pool of a single proxy which is not checked.
+func NewSingleProxyPool(name string, proxy *Proxy) (p *Pool) {
+	return &Pool{
+		name:      name,
+		balancing: BalancingRoundRobin,
+		members:   []*Member{{Proxy: proxy}},
+	}
+}
+
+// Name returns the name of the pool.
+func (p *Pool) Name() string {
+	return p.name
+}
+
+// IsChecked tells whether the members of the pool are health checked and
+// ejected on failures.
+func (p *Pool) IsChecked() bool {
+	return p.isChecked
+}
+
+// Members returns all the members of the pool, including ejected ones.
+func (p *Pool) Members() []*Member {
+	return p.members
+}
+
+// Candidates returns the healthy members of the pool in the order in which
+// they should be tried. The first member is selected by the balancing
+// method, the other members are used for retries.
+func (p *Pool) Candidates() (members []*Member, err error) {
+	members = make([]*Member, 0, len(p.members))
+	start := int(p.next.Add(1)-1) % len(p.members)
+	for i := range p.members {
+		m := p.members[(start+i)%len(p.members)]
+		if !m.isEjected.Load() {
+			members = append(members, m)
+		}
+	}
+
+	if len(members) == 0 {
+		return nil, fmt.Errorf(ErrNoHealthyMembers, p.name)
+	}
+
+	// The order of members having equal numbers of connections stays
+	// rotated, so that they share the load.
+	if p.balancing == BalancingLeastConnections {
+		slices.SortStableFunc(members, func(a, b *Member) int {
+			return int(a.connections.Load() - b.connections.Load())
+		})
+	}
+
+	return members, nil
+}
+
+// Eject removes the member from the candidates of a checked pool. When
+// isHandshakeRequired is set, the member is reinstated only after a
+// successful handshake. It returns true when the member has been healthy
+// before.
+func (p *Pool) Eject(m *Member, isHandshakeRequired bool) (isChanged bool) {
+	if !p.isChecked {
+		return false
+	}
+
+	if isHandshakeRequired {
+		m.isHandshakeRequired.Store(true)
+	}
+
+	return m.isEjected.CompareAndSwap(false, true)
+}
+
+// Reinstate returns the member to the candidates. isHandshakeDone tells
+// whether the check has completed a handshake with the member. It returns
+// true when the member has been ejected before.
+func (p *Pool) Reinstate(m *Member, isHandshakeDone bool) (isChanged bool) {
+	if m.isHandshakeRequired.Load() {
+		if !isHandshakeDone {
+			return false
+		}
+		m.isHandshakeRequired.Store(false)
+	}
+
+	return m.isEjected.CompareAndSwap(true, false)
+}
+
+// String returns the URL of the proxy for a single proxy pool and the name
+// of the pool otherwise.
+func (p *Pool) String() string {
+	if !p.isChecked {
+		return p.members[0].Proxy.String()
+	}
+
+	return p.name
+}
+
+// Acquire counts a connection through the member.
+func (m *Member) Acquire() {
+	m.connections.Add(1)
+}
+
+// Release stops counting a connection through the member.
+func (m *Member) Release() {
+	m.connections.Add(-1)
+}
+
+// IsEjected tells whether the member is ejected from its pool.
+func (m *Member) IsEjected() bool {
+	return m.isEjected.Load()
+}
+
+// Connections returns the number of connections through the member.
+func (m *Member) Connections() int64 {
+	return m.connections.Load()
+}
+
+// IsRefusal tells whether the error is a refusal of an upstream proxy to
+// connect to the target. Such an error is a reply of an upstream proxy which
+// has completed the handshake, so it describes the target, not the upstream
+// proxy, and the upstream proxy is considered healthy. Server errors, e.g.
+// '503 Service Unavailable' of an HTTP proxy or the general failure of a
+// SOCKS5 proxy, are usually sent when the target can not be reached. Proxies
+// which do not accept the credentials are not healthy.
+func IsRefusal(err error) bool {
+	var se *StatusError
+	if errors.As(err, &se) {
+		return se.StatusCode != http.StatusProxyAuthRequired
+	}
+
+	var re *socks.ReplyError
+	return errors.As(err, &re)
+}
+
+// IsAuthRejection tells whether the error is a rejection of the credentials
+// by an upstream proxy.
+func IsAuthRejection(err error) bool {
+	var se *StatusError
+	if errors.As(err, &se) {
+		return se.StatusCode == http.StatusProxyAuthRequired
+	}
+
+	var ae *socks.UserPassAuthError
+	return errors.As(err, &ae)
+}
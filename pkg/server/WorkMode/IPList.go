@@ -0,0 +1,196 @@
+package wm
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net/netip"
+	"os"
+	"strings"
+	"time"
+
+	ae "github.com/vault-thirteen/auxie/errors"
+)
+
+const (
+	ErrDuplicateIPAddressInList = "duplicate IP address or network in list: %v"
+	ErrListFileSyntax           = "list file '%v', line %d: %w"
+	ErrDuplicateExpiry          = "duplicate expiry"
+)
+
+const (
+	ListCommentPrefix  = "#"
+	ListExpiryPrefix   = "expires="
+	ListExpiryDateOnly = time.DateOnly
+)
+
+// IPList is a list of IP addresses and IP networks. Both IPv4 and IPv6 are
+// supported. Networks are written in CIDR notation, e.g. '10.0.0.0/22' or
+// 'fd00::/8'; a single address is a network with a full-length prefix.
+// IPv4-mapped IPv6 addresses and networks are stored as IPv4 ones.
+type IPList struct {
+	trie *PrefixTrie[*ListEntry]
+}
+
+// ListEntry is a single entry of the list of IP addresses.
+type ListEntry struct {
+	Prefix netip.Prefix
+
+	// Label is an optional free-form note, e.g. a name of a person or of a
+	// machine.
+	Label string
+
+	// ExpiresAt is an optional moment of time after which the entry stops
+	// matching. Zero value means that the entry never expires.
+	ExpiresAt time.Time
+}
+
+// IsActiveAt tells whether the entry has not expired at the specified time.
+func (e *ListEntry) IsActiveAt(t time.Time) bool {
+	return e.ExpiresAt.IsZero() || t.Before(e.ExpiresAt)
+}
+
+func NewIPList() *IPList {
+	return &IPList{
+		trie: NewPrefixTrie[*ListEntry](),
+	}
+}
+
+// NewIPListFromFile reads the list of IP addresses from a text file. Lines
+// may end either with LF or with CRLF. Each line has the following format:
+//
+//	<address or network> [<label>] [expires=<time>] [# <comment>]
+//
+// where the time is written either in RFC 3339 format or as a date in
+// 'YYYY-MM-DD' format; a date means the beginning of the day in UTC. Empty
+// lines and lines starting with '#' are ignored.
+func NewIPListFromFile(path string) (list *IPList, err error) {
+	var f *os.File
+	f, err = os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		derr := f.Close()
+		if derr != nil {
+			err = ae.Combine(err, derr)
+		}
+	}()
+
+	sc := bufio.NewScanner(f)
+	var lineNumber int
+	var entry *ListEntry
+	list = NewIPList()
+	for sc.Scan() {
+		lineNumber++
+
+		entry, err = ParseListEntry(sc.Text())
+		if err != nil {
+			return nil, fmt.Errorf(ErrListFileSyntax, path, lineNumber, err)
+		}
+		if entry == nil {
+			continue
+		}
+
+		if !list.trie.Insert(entry.Prefix, entry) {
+			err = fmt.Errorf(ErrDuplicateIPAddressInList, entry.Prefix)
+			return nil, fmt.Errorf(ErrListFileSyntax, path, lineNumber, err)
+		}
+	}
+
+	err = sc.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	return list, nil
+}
+
+// ParseListEntry parses a line of the list file. For empty lines and comment
+// lines, nil is returned without an error.
+func ParseListEntry(line string) (entry *ListEntry, err error) {
+	line, _, _ = strings.Cut(line, ListCommentPrefix)
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return nil, nil
+	}
+
+	entry = new(ListEntry)
+	entry.Prefix, err = ParsePrefix(fields[0])
+	if err != nil {
+		return nil, err
+	}
+
+	labelParts := make([]string, 0, len(fields)-1)
+	for _, field := range fields[1:] {
+		if !strings.HasPrefix(field, ListExpiryPrefix) {
+			labelParts = append(labelParts, field)
+			continue
+		}
+
+		if !entry.ExpiresAt.IsZero() {
+			return nil, errors.New(ErrDuplicateExpiry)
+		}
+
+		entry.ExpiresAt, err = parseExpiry(strings.TrimPrefix(field, ListExpiryPrefix))
+		if err != nil {
+			return nil, err
+		}
+	}
+	entry.Label = strings.Join(labelParts, " ")
+
+	return entry, nil
+}
+
+func parseExpiry(s string) (t time.Time, err error) {
+	t, err = time.Parse(time.RFC3339, s)
+	if err == nil {
+		return t, nil
+	}
+
+	return time.Parse(ListExpiryDateOnly, s)
+}
+
+// ParsePrefix parses either a single IP address or an IP network in CIDR
+// notation.
+func ParsePrefix(s string) (prefix netip.Prefix, err error) {
+	if !strings.Contains(s, "/") {
+		var addr netip.Addr
+		addr, err = netip.ParseAddr(s)
+		if err != nil {
+			return prefix, err
+		}
+
+		addr = addr.Unmap().WithZone("")
+		return netip.PrefixFrom(addr, addr.BitLen()), nil
+	}
+
+	prefix, err = netip.ParsePrefix(s)
+	if err != nil {
+		return prefix, err
+	}
+
+	// IPv4-mapped IPv6 network, e.g. '::ffff:10.0.0.0/104'.
+	if prefix.Addr().Is4In6() && (prefix.Bits() >= 96) {
+		prefix = netip.PrefixFrom(prefix.Addr().Unmap(), prefix.Bits()-96)
+	}
+
+	return prefix.Masked(), nil
+}
+
+// Contains checks whether the address is listed either directly or as a
+// part of a listed network. Expired entries are ignored.
+func (l *IPList) Contains(addr netip.Addr) bool {
+	_, ok := l.Lookup(addr)
+	return ok
+}
+
+// Lookup finds the most specific active entry containing the address.
+// Expired entries are ignored.
+func (l *IPList) Lookup(addr netip.Addr) (entry *ListEntry, ok bool) {
+	now := time.Now()
+	return l.trie.Lookup(addr.Unmap().WithZone(""), func(e *ListEntry) bool {
+		return e.IsActiveAt(now)
+	})
+}
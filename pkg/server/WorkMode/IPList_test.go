@@ -0,0 +1,184 @@
+package wm
+
+import (
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_ParseListEntry(t *testing.T) {
+	tests := []struct {
+		name          string
+		line          string
+		expectedEntry *ListEntry
+		isErr         bool
+	}{
+		{name: "empty line", line: ""},
+		{name: "blank line", line: " \t "},
+		{name: "comment line", line: "# 10.0.0.1"},
+		{
+			name:          "address",
+			line:          "10.0.0.1",
+			expectedEntry: &ListEntry{Prefix: netip.MustParsePrefix("10.0.0.1/32")},
+		},
+		{
+			name:          "network with host bits",
+			line:          "10.0.0.1/8",
+			expectedEntry: &ListEntry{Prefix: netip.MustParsePrefix("10.0.0.0/8")},
+		},
+		{
+			name:          "IPv6 network",
+			line:          "fd00::/8",
+			expectedEntry: &ListEntry{Prefix: netip.MustParsePrefix("fd00::/8")},
+		},
+		{
+			name:          "IPv4-mapped IPv6 address",
+			line:          "::ffff:10.0.0.1",
+			expectedEntry: &ListEntry{Prefix: netip.MustParsePrefix("10.0.0.1/32")},
+		},
+		{
+			name:          "IPv4-mapped IPv6 network",
+			line:          "::ffff:10.0.0.0/104",
+			expectedEntry: &ListEntry{Prefix: netip.MustParsePrefix("10.0.0.0/8")},
+		},
+		{
+			name:          "label and trailing comment",
+			line:          "10.0.0.1 office printer # second floor",
+			expectedEntry: &ListEntry{Prefix: netip.MustParsePrefix("10.0.0.1/32"), Label: "office printer"},
+		},
+		{
+			name: "expiry date",
+			line: "10.0.0.1 expires=2030-01-02",
+			expectedEntry: &ListEntry{
+				Prefix:    netip.MustParsePrefix("10.0.0.1/32"),
+				ExpiresAt: time.Date(2030, 1, 2, 0, 0, 0, 0, time.UTC),
+			},
+		},
+		{
+			name: "expiry time between label parts",
+			line: "10.0.0.1 alice expires=2030-01-02T03:04:05Z laptop",
+			expectedEntry: &ListEntry{
+				Prefix:    netip.MustParsePrefix("10.0.0.1/32"),
+				Label:     "alice laptop",
+				ExpiresAt: time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC),
+			},
+		},
+		{name: "bad address", line: "10.0.0.256", isErr: true},
+		{name: "bad network", line: "10.0.0.0/33", isErr: true},
+		{name: "bad expiry", line: "10.0.0.1 expires=tomorrow", isErr: true},
+		{name: "duplicate expiry", line: "10.0.0.1 expires=2030-01-02 expires=2030-01-03", isErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			entry, err := ParseListEntry(test.line)
+			if test.isErr {
+				if err == nil {
+					t.Fatalf("error is expected, entry is %+v", entry)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if test.expectedEntry == nil {
+				if entry != nil {
+					t.Errorf("entry is %+v, expected nil", entry)
+				}
+				return
+			}
+			if entry == nil {
+				t.Fatalf("entry is nil, expected %+v", test.expectedEntry)
+			}
+			if (entry.Prefix != test.expectedEntry.Prefix) ||
+				(entry.Label != test.expectedEntry.Label) ||
+				!entry.ExpiresAt.Equal(test.expectedEntry.ExpiresAt) {
+				t.Errorf("entry is %+v, expected %+v", entry, test.expectedEntry)
+			}
+		})
+	}
+}
+
+func Test_NewIPListFromFile(t *testing.T) {
+	tests := []struct {
+		name            string
+		data            string
+		listedAddrs     []string
+		notListedAddrs  []string
+		expectedErrLine string
+	}{
+		{
+			name: "LF line endings",
+			data: "# Office.\n10.0.0.0/8 office\n\nfd00::1\n",
+			listedAddrs: []string{
+				"10.1.2.3",
+				"::ffff:10.1.2.3",
+				"fd00::1",
+			},
+			notListedAddrs: []string{
+				"11.0.0.1",
+				"fd00::2",
+			},
+		},
+		{
+			name:           "CRLF line endings",
+			data:           "# Office.\r\n10.0.0.0/8 office\r\n\r\nfd00::1\r\n",
+			listedAddrs:    []string{"10.1.2.3", "fd00::1"},
+			notListedAddrs: []string{"11.0.0.1"},
+		},
+		{
+			name:           "expired entry",
+			data:           "10.0.0.0/8 expires=2000-01-01\n10.1.0.0/16 expires=2999-01-01\n",
+			listedAddrs:    []string{"10.1.2.3"},
+			notListedAddrs: []string{"10.2.0.1"},
+		},
+		{
+			name:            "syntax error",
+			data:            "10.0.0.1\n# Comment.\n10.0.0.256\n",
+			expectedErrLine: "line 3:",
+		},
+		{
+			name:            "duplicate network",
+			data:            "10.0.0.0/8\r\n10.1.2.3/8\r\n",
+			expectedErrLine: "line 2:",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "list.txt")
+			err := os.WriteFile(path, []byte(test.data), 0o600)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			list, err := NewIPListFromFile(path)
+			if len(test.expectedErrLine) > 0 {
+				if err == nil {
+					t.Fatalf("error is expected")
+				}
+				if !strings.Contains(err.Error(), test.expectedErrLine) {
+					t.Errorf("error %q does not contain %q", err, test.expectedErrLine)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			for _, addr := range test.listedAddrs {
+				if !list.Contains(netip.MustParseAddr(addr)) {
+					t.Errorf("address %v is not listed", addr)
+				}
+			}
+			for _, addr := range test.notListedAddrs {
+				if list.Contains(netip.MustParseAddr(addr)) {
+					t.Errorf("address %v is listed", addr)
+				}
+			}
+		})
+	}
+}
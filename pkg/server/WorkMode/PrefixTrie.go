@@ -0,0 +1,88 @@
+package wm
+
+import (
+	"net/netip"
+)
+
+// PrefixTrie is a binary trie of IP network prefixes with a value attached to
+// each prefix. It finds the networks containing an IP address in time
+// proportional to the address length, independently of the number of stored
+// prefixes. IPv4 and IPv6 prefixes are stored in separate trees.
+type PrefixTrie[T any] struct {
+	rootV4 *prefixTrieNode[T]
+	rootV6 *prefixTrieNode[T]
+}
+
+type prefixTrieNode[T any] struct {
+	children   [2]*prefixTrieNode[T]
+	isTerminal bool
+	value      T
+}
+
+func NewPrefixTrie[T any]() *PrefixTrie[T] {
+	return &PrefixTrie[T]{
+		rootV4: new(prefixTrieNode[T]),
+		rootV6: new(prefixTrieNode[T]),
+	}
+}
+
+// Insert adds a prefix with its value to the trie. Host bits of the prefix
+// are ignored. If the prefix is already stored in the trie, nothing is
+// changed and false is returned.
+func (t *PrefixTrie[T]) Insert(prefix netip.Prefix, value T) (isNew bool) {
+	prefix = prefix.Masked()
+	addrBytes := prefix.Addr().AsSlice()
+
+	node := t.root(prefix.Addr())
+	for i := 0; i < prefix.Bits(); i++ {
+		bit := getBit(addrBytes, i)
+		if node.children[bit] == nil {
+			node.children[bit] = new(prefixTrieNode[T])
+		}
+		node = node.children[bit]
+	}
+
+	if node.isTerminal {
+		return false
+	}
+
+	node.isTerminal = true
+	node.value = value
+	return true
+}
+
+// Lookup finds the most specific stored prefix containing the address whose
+// value is accepted by the filter. A nil filter accepts all values.
+func (t *PrefixTrie[T]) Lookup(addr netip.Addr, filter func(T) bool) (value T, ok bool) {
+	addrBytes := addr.AsSlice()
+
+	node := t.root(addr)
+	for i := 0; i <= addr.BitLen(); i++ {
+		if node.isTerminal && ((filter == nil) || filter(node.value)) {
+			value, ok = node.value, true
+		}
+		if i == addr.BitLen() {
+			break
+		}
+
+		node = node.children[getBit(addrBytes, i)]
+		if node == nil {
+			break
+		}
+	}
+
+	return value, ok
+}
+
+func (t *PrefixTrie[T]) root(addr netip.Addr) *prefixTrieNode[T] {
+	if addr.Is4() {
+		return t.rootV4
+	}
+	return t.rootV6
+}
+
+// getBit returns the bit of the byte slice at the specified position counting
+// from the most significant bit of the first byte.
+func getBit(ba []byte, position int) byte {
+	return (ba[position/8] >> (7 - position%8)) & 1
+}
@@ -0,0 +1,112 @@
+package wm
+
+import (
+	"net/netip"
+	"testing"
+)
+
+func Test_PrefixTrie_Lookup(t *testing.T) {
+	trie := NewPrefixTrie[string]()
+	for _, p := range []string{
+		"0.0.0.0/0",
+		"10.0.0.0/8",
+		"10.1.0.0/16",
+		"10.1.2.3/32",
+		"::/0",
+		"fd00::/8",
+		"fd00::1/128",
+		"::ffff:0:0/96",
+	} {
+		if !trie.Insert(netip.MustParsePrefix(p), p) {
+			t.Fatalf("prefix %v is not inserted", p)
+		}
+	}
+
+	tests := []struct {
+		name          string
+		addr          string
+		expectedValue string
+	}{
+		{name: "IPv4 default route", addr: "192.168.0.1", expectedValue: "0.0.0.0/0"},
+		{name: "IPv4 /8", addr: "10.200.0.1", expectedValue: "10.0.0.0/8"},
+		{name: "IPv4 /16 inside /8", addr: "10.1.200.1", expectedValue: "10.1.0.0/16"},
+		{name: "IPv4 /32", addr: "10.1.2.3", expectedValue: "10.1.2.3/32"},
+		{name: "IPv4 neighbour of /32", addr: "10.1.2.4", expectedValue: "10.1.0.0/16"},
+		{name: "IPv6 default route", addr: "2001:db8::1", expectedValue: "::/0"},
+		{name: "IPv6 /8", addr: "fd12::1", expectedValue: "fd00::/8"},
+		{name: "IPv6 /128", addr: "fd00::1", expectedValue: "fd00::1/128"},
+		{name: "IPv6 neighbour of /128", addr: "fd00::2", expectedValue: "fd00::/8"},
+		{
+			// Mapped addresses are not unmapped by the trie, they are
+			// looked up in the IPv6 tree.
+			name:          "IPv4-mapped IPv6",
+			addr:          "::ffff:10.1.2.3",
+			expectedValue: "::ffff:0:0/96",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			value, ok := trie.Lookup(netip.MustParseAddr(test.addr), nil)
+			if !ok {
+				t.Fatalf("address %v is not found", test.addr)
+			}
+			if value != test.expectedValue {
+				t.Errorf("value is %q, expected %q", value, test.expectedValue)
+			}
+		})
+	}
+}
+
+func Test_PrefixTrie_SeparateFamilies(t *testing.T) {
+	trie := NewPrefixTrie[string]()
+	trie.Insert(netip.MustParsePrefix("0.0.0.0/0"), "v4")
+
+	// The IPv4 default route and the IPv6 prefix '::/0' have equal bits,
+	// but they must not overlap.
+	if _, ok := trie.Lookup(netip.MustParseAddr("::"), nil); ok {
+		t.Errorf("IPv6 address is found in the IPv4 tree")
+	}
+	if !trie.Insert(netip.MustParsePrefix("::/0"), "v6") {
+		t.Errorf("IPv6 default route is not inserted")
+	}
+	if value, _ := trie.Lookup(netip.MustParseAddr("0.0.0.0"), nil); value != "v4" {
+		t.Errorf("value is %q, expected %q", value, "v4")
+	}
+}
+
+func Test_PrefixTrie_Insert(t *testing.T) {
+	trie := NewPrefixTrie[int]()
+	if !trie.Insert(netip.MustParsePrefix("10.1.2.3/16"), 1) {
+		t.Fatalf("new prefix is not inserted")
+	}
+
+	// Host bits are ignored, so this is the same prefix.
+	if trie.Insert(netip.MustParsePrefix("10.1.0.0/16"), 2) {
+		t.Errorf("duplicate prefix is inserted")
+	}
+	if value, _ := trie.Lookup(netip.MustParseAddr("10.1.9.9"), nil); value != 1 {
+		t.Errorf("value is %d, expected %d", value, 1)
+	}
+	if _, ok := trie.Lookup(netip.MustParseAddr("10.2.0.0"), nil); ok {
+		t.Errorf("address outside of the prefix is found")
+	}
+}
+
+func Test_PrefixTrie_LookupFilter(t *testing.T) {
+	trie := NewPrefixTrie[int]()
+	trie.Insert(netip.MustParsePrefix("10.0.0.0/8"), 8)
+	trie.Insert(netip.MustParsePrefix("10.1.0.0/16"), 16)
+	trie.Insert(netip.MustParsePrefix("10.1.2.0/24"), 24)
+
+	// A rejected most specific prefix falls back to a less specific one.
+	value, ok := trie.Lookup(netip.MustParseAddr("10.1.2.3"), func(v int) bool { return v != 24 })
+	if !ok || (value != 16) {
+		t.Errorf("value is %d (%v), expected %d", value, ok, 16)
+	}
+
+	_, ok = trie.Lookup(netip.MustParseAddr("10.1.2.3"), func(int) bool { return false })
+	if ok {
+		t.Errorf("value is found while all values are rejected")
+	}
+}
@@ -2,7 +2,11 @@ package wm
 
 import (
 	"fmt"
+	"os"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 const (
@@ -11,21 +15,38 @@ const (
 
 // WorkModeString.
 const (
-	WorkModeStringDefault = WorkModeStringPublic
-	WorkModeStringPublic  = "public"
-	WorkModeStringPrivate = "private"
+	WorkModeStringDefault    = WorkModeStringPublic
+	WorkModeStringPublic     = "public"
+	WorkModeStringPrivate    = "private"
+	WorkModeStringRestricted = "restricted"
 )
 
 // WorkModeByte.
 const (
-	WorkModePublic  = 1
-	WorkModePrivate = 2
+	WorkModePublic     = 1
+	WorkModePrivate    = 2
+	WorkModeRestricted = 3
 )
 
+// WorkMode is a work mode of the proxy server together with its list of IP
+// addresses. The list is used differently depending on the mode:
+//   - in the public mode, the list is not used at all;
+//   - in the private mode, the list is a white list;
+//   - in the restricted mode, the list is a black list.
+//
+// The list may be reloaded from its file while the server is running. A new
+// list is built aside and then swapped in atomically, so that readers never
+// see a partially built list. If the new file can not be parsed, the old list
+// is kept.
 type WorkMode struct {
-	mode              byte
-	whitelistFilePath string
-	whiteListMap      WhiteListMap
+	mode         byte
+	listFilePath string
+	list         atomic.Pointer[IPList]
+
+	// Reload control.
+	reloadLock      sync.Mutex
+	listFileModTime time.Time
+	listFileSize    int64
 }
 
 func New(workModeString string, listFile string) (wm *WorkMode, err error) {
@@ -37,6 +58,9 @@ func New(workModeString string, listFile string) (wm *WorkMode, err error) {
 	case strings.ToLower(WorkModeStringPrivate):
 		mode = WorkModePrivate
 
+	case strings.ToLower(WorkModeStringRestricted):
+		mode = WorkModeRestricted
+
 	default:
 		return nil, fmt.Errorf(ErrUnknownWorkModeString, workModeString)
 	}
@@ -45,11 +69,12 @@ func New(workModeString string, listFile string) (wm *WorkMode, err error) {
 		mode: mode,
 	}
 
-	// Configure a white-list for the private mode.
-	if mode == WorkModePrivate {
-		wm.whitelistFilePath = listFile
+	// Configure a white-list for the private mode or a black-list for the
+	// restricted mode.
+	if wm.UsesList() {
+		wm.listFilePath = listFile
 
-		wm.whiteListMap, err = NewWhiteListMapFromFile(wm.whitelistFilePath)
+		err = wm.Reload()
 		if err != nil {
 			return nil, err
 		}
@@ -60,6 +85,80 @@ func New(workModeString string, listFile string) (wm *WorkMode, err error) {
 	return wm, nil
 }
 
+// UsesList tells whether the work mode uses a list of IP addresses.
+func (wm *WorkMode) UsesList() bool {
+	return (wm.mode == WorkModePrivate) || (wm.mode == WorkModeRestricted)
+}
+
+// Reload reads the list of IP addresses from its file and replaces the
+// current list with the new one. On error, the current list is not changed.
+func (wm *WorkMode) Reload() (err error) {
+	if !wm.UsesList() {
+		return nil
+	}
+
+	wm.reloadLock.Lock()
+	defer wm.reloadLock.Unlock()
+
+	return wm.reload()
+}
+
+// ReloadIfChanged reloads the list of IP addresses if its file has been
+// modified since the last check.
+func (wm *WorkMode) ReloadIfChanged() (isReloaded bool, err error) {
+	if !wm.UsesList() {
+		return false, nil
+	}
+
+	wm.reloadLock.Lock()
+	defer wm.reloadLock.Unlock()
+
+	var fi os.FileInfo
+	fi, err = os.Stat(wm.listFilePath)
+	if err != nil {
+		return false, err
+	}
+
+	if fi.ModTime().Equal(wm.listFileModTime) && (fi.Size() == wm.listFileSize) {
+		return false, nil
+	}
+
+	// A broken file is reported only once, not on every check.
+	wm.listFileModTime = fi.ModTime()
+	wm.listFileSize = fi.Size()
+
+	err = wm.reload()
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+func (wm *WorkMode) reload() (err error) {
+	var fi os.FileInfo
+	fi, err = os.Stat(wm.listFilePath)
+	if err != nil {
+		return err
+	}
+
+	var list *IPList
+	list, err = NewIPListFromFile(wm.listFilePath)
+	if err != nil {
+		return err
+	}
+
+	wm.list.Store(list)
+	wm.listFileModTime = fi.ModTime()
+	wm.listFileSize = fi.Size()
+
+	return nil
+}
+
+func (wm *WorkMode) ListFilePath() string {
+	return wm.listFilePath
+}
+
 func (wm *WorkMode) IsPublic() bool {
 	return wm.mode == WorkModePublic
 }
@@ -68,6 +167,10 @@ func (wm *WorkMode) IsPrivate() bool {
 	return wm.mode == WorkModePrivate
 }
 
-func (wm *WorkMode) WhiteList() WhiteListMap {
-	return wm.whiteListMap
+func (wm *WorkMode) IsRestricted() bool {
+	return wm.mode == WorkModeRestricted
+}
+
+func (wm *WorkMode) List() *IPList {
+	return wm.list.Load()
 }
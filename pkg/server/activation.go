@@ -0,0 +1,54 @@
+package server
+
+import (
+	"fmt"
+	"net"
+)
+
+const (
+	ErrInheritedSocketIsNotFound    = "inherited socket is not found: %v"
+	ErrSeveralInheritedSockets      = "several inherited sockets have the name: %v"
+	ErrInheritedSocketIsNotListener = "inherited socket %d is not a listening socket: %w"
+)
+
+// Socket activation of systemd.
+const (
+	ListenNetworkSystemd = "systemd"
+
+	EnvListenPid     = "LISTEN_PID"
+	EnvListenFds     = "LISTEN_FDS"
+	EnvListenFdNames = "LISTEN_FDNAMES"
+
+	// ListenFdsStart is the first file descriptor passed by systemd.
+	ListenFdsStart = 3
+
+	ListenFdNamesSeparator = ":"
+)
+
+// inheritedSocket is a listening socket passed to the server by systemd.
+// Name of the socket is set with the 'FileDescriptorName' option of the
+// socket unit; by default, it is the name of the socket unit.
+type inheritedSocket struct {
+	name      string
+	listener  net.Listener
+	isClaimed bool
+}
+
+// claimInheritedSockets returns the inherited sockets which have the name.
+// Every socket may be claimed only once.
+func claimInheritedSockets(sockets []*inheritedSocket, name string) (claimed []*inheritedSocket, err error) {
+	for _, socket := range sockets {
+		if socket.isClaimed || (socket.name != name) {
+			continue
+		}
+
+		socket.isClaimed = true
+		claimed = append(claimed, socket)
+	}
+
+	if len(claimed) == 0 {
+		return nil, fmt.Errorf(ErrInheritedSocketIsNotFound, name)
+	}
+
+	return claimed, nil
+}
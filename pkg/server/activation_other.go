@@ -0,0 +1,9 @@
+//go:build !unix
+
+package server
+
+// inheritSockets does nothing, socket activation is supported only by Unix
+// systems.
+func inheritSockets() (sockets []*inheritedSocket, err error) {
+	return nil, nil
+}
@@ -0,0 +1,62 @@
+//go:build unix
+
+package server
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// inheritSockets takes the listening sockets passed to the server by systemd.
+// When the server is not started by socket activation, nothing is returned.
+// Environment variables of socket activation are removed, so that they are
+// not inherited by child processes.
+func inheritSockets() (sockets []*inheritedSocket, err error) {
+	pid, err := strconv.Atoi(os.Getenv(EnvListenPid))
+	if (err != nil) || (pid != os.Getpid()) {
+		return nil, nil
+	}
+
+	var n int
+	n, err = strconv.Atoi(os.Getenv(EnvListenFds))
+	if (err != nil) || (n <= 0) {
+		return nil, nil
+	}
+
+	names := strings.Split(os.Getenv(EnvListenFdNames), ListenFdNamesSeparator)
+
+	for _, env := range []string{EnvListenPid, EnvListenFds, EnvListenFdNames} {
+		err = os.Unsetenv(env)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	sockets = make([]*inheritedSocket, 0, n)
+	for fd := ListenFdsStart; fd < ListenFdsStart+n; fd++ {
+		socket := &inheritedSocket{}
+		if fd-ListenFdsStart < len(names) {
+			socket.name = names[fd-ListenFdsStart]
+		}
+
+		syscall.CloseOnExec(fd)
+
+		f := os.NewFile(uintptr(fd), socket.name)
+		socket.listener, err = net.FileListener(f)
+		cerr := f.Close()
+		if err != nil {
+			return nil, fmt.Errorf(ErrInheritedSocketIsNotListener, fd, err)
+		}
+		if cerr != nil {
+			return nil, cerr
+		}
+
+		sockets = append(sockets, socket)
+	}
+
+	return sockets, nil
+}
@@ -0,0 +1,58 @@
+package server
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/vault-thirteen/auxie/header"
+)
+
+const (
+	AuthSchemeBasic = "Basic"
+)
+
+// isAuthenticationRequired tells whether clients must authenticate with a
+// user name and a password.
+func (s *Server) isAuthenticationRequired() bool {
+	return s.parameters.userDB != nil
+}
+
+// authenticateClient checks the credentials sent by the client in the
+// 'Proxy-Authorization' HTTP header. On success, the user name is returned.
+func (s *Server) authenticateClient(req *http.Request) (userName string, ok bool) {
+	var password string
+	userName, password, ok = parseBasicCredentials(req.Header.Get(header.HttpHeaderProxyAuthorization))
+	if !ok {
+		return "", false
+	}
+
+	if !s.parameters.userDB.Authenticate(userName, password) {
+		return "", false
+	}
+
+	return userName, true
+}
+
+// parseBasicCredentials parses credentials of the 'Basic' HTTP authentication
+// scheme, RFC 7617.
+func parseBasicCredentials(value string) (userName string, password string, ok bool) {
+	scheme, encoded, found := strings.Cut(strings.TrimSpace(value), " ")
+	if !found || !strings.EqualFold(scheme, AuthSchemeBasic) {
+		return "", "", false
+	}
+
+	decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return "", "", false
+	}
+
+	return strings.Cut(string(decoded), ":")
+}
+
+func (s *Server) respondWithProxyAuthenticationRequired(w http.ResponseWriter) {
+	w.Header().Set(header.HttpHeaderProxyAuthenticate,
+		fmt.Sprintf(`%s realm="%s", charset="UTF-8"`, AuthSchemeBasic, s.parameters.AuthRealm))
+	http.Error(w, http.StatusText(http.StatusProxyAuthRequired), http.StatusProxyAuthRequired)
+}
@@ -0,0 +1,163 @@
+package server
+
+import (
+	"crypto/tls"
+	"log"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	zlog "github.com/rs/zerolog/log"
+)
+
+// certificateStore holds the certificate of the TLS listener. The certificate
+// may be reloaded from its files while the server is running; new TLS
+// handshakes use the new certificate, established connections are not
+// affected. If new files can not be loaded, the old certificate is kept.
+type certificateStore struct {
+	certFile    string
+	keyFile     string
+	certificate atomic.Pointer[tls.Certificate]
+
+	// Reload control.
+	reloadLock      sync.Mutex
+	certFileModTime time.Time
+	keyFileModTime  time.Time
+}
+
+func newCertificateStore(certFile string, keyFile string) (cs *certificateStore, err error) {
+	cs = &certificateStore{
+		certFile: certFile,
+		keyFile:  keyFile,
+	}
+
+	err = cs.Reload()
+	if err != nil {
+		return nil, err
+	}
+
+	return cs, nil
+}
+
+// GetCertificate is a callback for the TLS configuration.
+func (cs *certificateStore) GetCertificate(_ *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return cs.certificate.Load(), nil
+}
+
+// Reload reads the certificate and the key from their files.
+func (cs *certificateStore) Reload() (err error) {
+	cs.reloadLock.Lock()
+	defer cs.reloadLock.Unlock()
+
+	var certFileModTime, keyFileModTime time.Time
+	certFileModTime, keyFileModTime, err = cs.getModTimes()
+	if err != nil {
+		return err
+	}
+
+	return cs.reload(certFileModTime, keyFileModTime)
+}
+
+// ReloadIfChanged reloads the certificate if any of its files has been
+// modified since the last check.
+func (cs *certificateStore) ReloadIfChanged() (isReloaded bool, err error) {
+	cs.reloadLock.Lock()
+	defer cs.reloadLock.Unlock()
+
+	var certFileModTime, keyFileModTime time.Time
+	certFileModTime, keyFileModTime, err = cs.getModTimes()
+	if err != nil {
+		return false, err
+	}
+
+	if certFileModTime.Equal(cs.certFileModTime) && keyFileModTime.Equal(cs.keyFileModTime) {
+		return false, nil
+	}
+
+	err = cs.reload(certFileModTime, keyFileModTime)
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+func (cs *certificateStore) reload(certFileModTime time.Time, keyFileModTime time.Time) (err error) {
+	// Files are usually replaced one after another. A broken pair is reported
+	// only once, and it is loaded again when any of the files changes.
+	cs.certFileModTime = certFileModTime
+	cs.keyFileModTime = keyFileModTime
+
+	var certificate tls.Certificate
+	certificate, err = tls.LoadX509KeyPair(cs.certFile, cs.keyFile)
+	if err != nil {
+		return err
+	}
+
+	cs.certificate.Store(&certificate)
+	return nil
+}
+
+func (cs *certificateStore) getModTimes() (certFileModTime time.Time, keyFileModTime time.Time, err error) {
+	var fi os.FileInfo
+	fi, err = os.Stat(cs.certFile)
+	if err != nil {
+		return certFileModTime, keyFileModTime, err
+	}
+	certFileModTime = fi.ModTime()
+
+	fi, err = os.Stat(cs.keyFile)
+	if err != nil {
+		return certFileModTime, keyFileModTime, err
+	}
+	keyFileModTime = fi.ModTime()
+
+	return certFileModTime, keyFileModTime, nil
+}
+
+// ReloadCertificate reloads the certificate of the TLS listener. If the new
+// certificate can not be loaded, the old one is kept and an error is
+// returned.
+func (s *Server) ReloadCertificate() (err error) {
+	if s.parameters.tlsCertificate == nil {
+		return nil
+	}
+
+	err = s.parameters.tlsCertificate.Reload()
+	if err != nil {
+		return err
+	}
+
+	log.Println("TLS certificate has been reloaded: " + s.parameters.TlsCertFile)
+	return nil
+}
+
+// watchCertificateFiles periodically checks the files of the certificate and
+// reloads the certificate when the files are changed.
+func (s *Server) watchCertificateFiles() {
+	defer s.subRoutines.Done()
+
+	ticker := time.NewTicker(time.Second * time.Duration(s.parameters.TlsCertCheckPeriod))
+	defer ticker.Stop()
+
+	var isReloaded bool
+	var err error
+	for {
+		select {
+		case <-s.quit:
+			log.Println("Certificate file watcher has stopped.")
+			return
+
+		case <-ticker.C:
+			isReloaded, err = s.parameters.tlsCertificate.ReloadIfChanged()
+			if err != nil {
+				zlog.Error().Err(err).Msg("certificate reload error, old certificate is kept")
+				continue
+			}
+			if isReloaded {
+				log.Println("TLS certificate has been reloaded: " + s.parameters.TlsCertFile)
+			}
+		}
+	}
+}
@@ -0,0 +1,37 @@
+package server
+
+import (
+	"fmt"
+	"net/netip"
+)
+
+// client is a description of a client of the proxy server which is used for
+// access control and logging.
+type client struct {
+	IPAddress netip.Addr
+
+	// Label of the list entry matching the client's IP address.
+	Label string
+
+	// UserName is a name of the authenticated user. It is empty when the
+	// client is not authenticated. For clients authenticated with a TLS
+	// certificate, it is the common name of the certificate's subject.
+	UserName string
+
+	// CertificateSubject is a subject of the client's verified TLS
+	// certificate.
+	CertificateSubject string
+}
+
+func (c *client) String() string {
+	s := fmt.Sprintf("'%v'", c.IPAddress)
+	if len(c.Label) > 0 {
+		s += fmt.Sprintf(" (%s)", c.Label)
+	}
+	if len(c.CertificateSubject) > 0 {
+		s += fmt.Sprintf(" with certificate '%s'", c.CertificateSubject)
+	} else if len(c.UserName) > 0 {
+		s += fmt.Sprintf(" as user '%s'", c.UserName)
+	}
+	return s
+}
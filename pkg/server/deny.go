@@ -0,0 +1,81 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	zlog "github.com/rs/zerolog/log"
+)
+
+const (
+	ErrUnknownDenialResponse      = "unknown denial response: %v"
+	ErrDenialResponseWithoutUsers = "denial response 407 requires a file of users"
+)
+
+// DenialResponse is a response sent to clients which are not allowed to use
+// the proxy by the work mode.
+const (
+	DenialResponseClose                    = "close"
+	DenialResponseForbidden                = "403"
+	DenialResponseProxyAuthenticationFirst = "407"
+	DenialResponseDefault                  = DenialResponseClose
+)
+
+// Reasons of denial.
+const (
+	DenialReasonIPAddress          = "IP address is not allowed"
+	DenialReasonAuthentication     = "authentication failed"
+	DenialReasonPort               = "destination port is not allowed"
+	DenialReasonDestination        = "destination is not allowed"
+	DenialReasonDestinationAddress = "destination address is not allowed"
+)
+
+func checkDenialResponse(denialResponse string) (err error) {
+	switch strings.ToLower(denialResponse) {
+	case DenialResponseClose,
+		DenialResponseForbidden,
+		DenialResponseProxyAuthenticationFirst:
+		return nil
+	default:
+		return fmt.Errorf(ErrUnknownDenialResponse, denialResponse)
+	}
+}
+
+// canCredentialsOverrideDenial tells whether a client which is not allowed to
+// use the proxy by the work mode is let in by valid credentials. It is so
+// when the client is prompted for credentials by the '407' denial response.
+func (s *Server) canCredentialsOverrideDenial() bool {
+	return strings.ToLower(s.parameters.DenialResponse) == DenialResponseProxyAuthenticationFirst
+}
+
+// logDenial logs a denied request with the reason of denial.
+func (s *Server) logDenial(cli *client, target string, reason string) {
+	zlog.WithLevel(s.parameters.denialLogLevel).
+		Msgf("request to '%s' from client %v is denied: %s", target, cli, reason)
+}
+
+// denyClient responds to a client which is not allowed to use the proxy. The
+// response is selected by the server's parameters: the connection is either
+// closed silently, or a '403 Forbidden' response is sent, or a '407 Proxy
+// Authentication Required' response is sent to prompt for credentials, which
+// let the client in.
+func (s *Server) denyClient(w http.ResponseWriter, req *http.Request, cli *client, reason string) {
+	s.logDenial(cli, req.URL.String(), reason)
+
+	switch strings.ToLower(s.parameters.DenialResponse) {
+	case DenialResponseForbidden:
+		http.Error(w, "access to the proxy is denied", http.StatusForbidden)
+
+	case DenialResponseProxyAuthenticationFirst:
+		s.respondWithProxyAuthenticationRequired(w)
+
+	default:
+		err := s.breakConnection(w)
+		if err != nil {
+			zlog.Error().Err(err).Msg("")
+			s.respondWithInternalServerError(w)
+			return
+		}
+	}
+}
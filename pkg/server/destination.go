@@ -0,0 +1,81 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	dp "github.com/vault-thirteen/Forward-Proxy/pkg/server/DestinationPolicy"
+)
+
+const (
+	ErrDestinationHostIsEmpty = "destination host is empty"
+)
+
+const (
+	PortHttpDefault  = 80
+	PortHttpsDefault = 443
+)
+
+// getDestination returns the host and the port of the request's target. When
+// the port is not set explicitly, it is taken from the URL's scheme.
+func getDestination(req *http.Request) (host string, port uint16, err error) {
+	host = req.URL.Hostname()
+	if len(host) == 0 {
+		return "", 0, errors.New(ErrDestinationHostIsEmpty)
+	}
+
+	portStr := req.URL.Port()
+	if len(portStr) > 0 {
+		port, err = dp.ParsePort(portStr)
+		if err != nil {
+			return "", 0, err
+		}
+		return host, port, nil
+	}
+
+	if (req.Method == http.MethodConnect) || strings.EqualFold(req.URL.Scheme, "https") {
+		return host, PortHttpsDefault, nil
+	}
+
+	return host, PortHttpDefault, nil
+}
+
+// isPortAllowed checks the destination port against the set of allowed ports.
+// CONNECT requests and plain HTTP requests have separate sets of ports.
+func (s *Server) isPortAllowed(method string, port uint16) (ok bool) {
+	if method == http.MethodConnect {
+		return s.parameters.allowedPortsConnect.Contains(port)
+	}
+
+	return s.parameters.allowedPortsHttp.Contains(port)
+}
+
+// isDestinationAllowed checks the destination against the destination policy.
+// When the destination is denied, the reason is returned for logging.
+func (s *Server) isDestinationAllowed(host string, port uint16) (ok bool, reason string) {
+	if s.parameters.destinationPolicy == nil {
+		return true, ""
+	}
+
+	var rule *dp.Rule
+	ok, rule = s.parameters.destinationPolicy.IsAllowed(host, port)
+	if ok {
+		return true, ""
+	}
+
+	if rule == nil {
+		return false, DenialReasonDestination + " by default"
+	}
+
+	return false, fmt.Sprintf("%s by rule '%v'", DenialReasonDestination, rule)
+}
+
+func (s *Server) respondWithForbiddenPort(w http.ResponseWriter) {
+	http.Error(w, "destination port is forbidden by proxy policy", http.StatusForbidden)
+}
+
+func (s *Server) respondWithForbiddenDestination(w http.ResponseWriter) {
+	http.Error(w, "destination is forbidden by proxy policy", http.StatusForbidden)
+}
@@ -0,0 +1,118 @@
+package server
+
+import (
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"time"
+
+	"github.com/vault-thirteen/auxie/header"
+
+	pp "github.com/vault-thirteen/Forward-Proxy/pkg/server/ProxyProtocol"
+)
+
+const (
+	HttpHeaderXForwardedFor = "X-Forwarded-For"
+
+	// ProxyProtocolHeaderTimeout is the time given to a load balancer to send
+	// the header of the PROXY protocol.
+	ProxyProtocolHeaderTimeout = time.Second * 10
+)
+
+// newProxyProtocolListener wraps the listener to accept the PROXY protocol.
+// Only trusted proxies may connect, because any peer sending the header could
+// claim any source address. The list of trusted proxies is required by the
+// parameters when the PROXY protocol is used.
+func (s *Server) newProxyProtocolListener(listener net.Listener) net.Listener {
+	isPeerTrusted := func(addr net.Addr) bool {
+		ipaddr, err := parseHostPortAddress(addr.String())
+		if err != nil {
+			return false
+		}
+		return s.isTrustedProxy(ipaddr)
+	}
+
+	return pp.NewListener(listener, ProxyProtocolHeaderTimeout, isPeerTrusted)
+}
+
+func (s *Server) isTrustedProxy(ipaddr netip.Addr) bool {
+	return prefixListContains(s.parameters.trustedProxies, ipaddr)
+}
+
+// getForwardedClientAddress takes the client's address from the 'Forwarded'
+// HTTP header, RFC 7239, or, when it is absent, from the 'X-Forwarded-For'
+// HTTP header. The chain of addresses is walked from the nearest hop to the
+// farthest one, and the first address which is not a trusted proxy is the
+// client's address. Addresses written by the client itself can not be
+// forged this way, because they are farther than the first untrusted hop.
+func (s *Server) getForwardedClientAddress(req *http.Request) (ipaddr netip.Addr, ok bool) {
+	var chain []string
+	if values := req.Header.Values(header.HttpHeaderForwarded); len(values) > 0 {
+		chain = parseForwardedHeader(values)
+	} else {
+		chain = parseXForwardedForHeader(req.Header.Values(HttpHeaderXForwardedFor))
+	}
+	if len(chain) == 0 {
+		return ipaddr, false
+	}
+
+	var err error
+	for i := len(chain) - 1; i >= 0; i-- {
+		ipaddr, err = parseForwardedAddress(chain[i])
+		if err != nil {
+			// Unknown and obfuscated identifiers can not be checked.
+			return ipaddr, false
+		}
+
+		if !s.isTrustedProxy(ipaddr) {
+			return ipaddr, true
+		}
+	}
+
+	// All the hops are trusted proxies.
+	return ipaddr, true
+}
+
+// parseXForwardedForHeader lists the addresses of the 'X-Forwarded-For'
+// HTTP header, e.g. 'X-Forwarded-For: 203.0.113.195, 2001:db8::1'.
+func parseXForwardedForHeader(values []string) (chain []string) {
+	for _, value := range values {
+		for _, part := range strings.Split(value, ",") {
+			part = strings.TrimSpace(part)
+			if len(part) > 0 {
+				chain = append(chain, part)
+			}
+		}
+	}
+
+	return chain
+}
+
+// parseForwardedHeader lists the 'for' parameters of the 'Forwarded' HTTP
+// header, e.g. 'Forwarded: for=192.0.2.43, for="[2001:db8::17]:4711"'.
+func parseForwardedHeader(values []string) (chain []string) {
+	for _, value := range values {
+		for _, element := range strings.Split(value, ",") {
+			for _, pair := range strings.Split(element, ";") {
+				key, val, found := strings.Cut(strings.TrimSpace(pair), "=")
+				if found && strings.EqualFold(key, "for") {
+					chain = append(chain, strings.Trim(val, `"`))
+				}
+			}
+		}
+	}
+
+	return chain
+}
+
+// parseForwardedAddress parses an address of a forwarding header. The
+// address may have a port and IPv6 addresses may be enclosed in brackets.
+func parseForwardedAddress(s string) (ipaddr netip.Addr, err error) {
+	ipaddr, err = netip.ParseAddr(strings.Trim(s, "[]"))
+	if err == nil {
+		return ipaddr.Unmap().WithZone(""), nil
+	}
+
+	return parseHostPortAddress(s)
+}
@@ -3,48 +3,134 @@ package server
 import (
 	"compress/gzip"
 	"context"
+	"errors"
+	"fmt"
 	"io"
 	"net"
 	"net/http"
+	"os"
 	"time"
 
 	zlog "github.com/rs/zerolog/log"
 	"github.com/vault-thirteen/auxie/BOM/Reader"
 	slreader "github.com/vault-thirteen/auxie/SLReader"
 	"github.com/vault-thirteen/auxie/header"
+
+	relay "github.com/vault-thirteen/Forward-Proxy/pkg/server/Relay"
+	upstream "github.com/vault-thirteen/Forward-Proxy/pkg/server/Upstream"
 )
 
 const BCST = time.Millisecond * 50
 
-func (s *Server) router(w http.ResponseWriter, req *http.Request) {
+func (s *Server) router(w http.ResponseWriter, req *http.Request, ls *listener) {
 	var t1 = time.Now()
 
-	clientIPAddr, err := s.getClientIPAddress(req)
+	s.normaliseHttp2Request(req)
+
+	var err error
+	cli := new(client)
+	cli.IPAddress, err = s.getClientIPAddress(req, ls)
 	if err != nil {
 		zlog.Error().Err(err).Msg("")
 		return
 	}
 
-	ok := s.isIPAddressAllowed(clientIPAddr)
+	var ok bool
+	ok, cli.Label = isIPAddressAllowed(ls.workMode, cli.IPAddress)
+	isIPAddressDenied := !ok
+	if isIPAddressDenied && !s.canCredentialsOverrideDenial() {
+		s.denyClient(w, req, cli, DenialReasonIPAddress)
+		return
+	}
+
+	// Requests to the proxy itself, e.g. for the PAC file, are served
+	// before the authentication, because browsers fetch the PAC file
+	// without credentials.
+	if isOriginFormRequest(req) {
+		if isIPAddressDenied {
+			s.denyClient(w, req, cli, DenialReasonIPAddress)
+			return
+		}
+		s.serveOriginFormRequest(w, req)
+		return
+	}
+
+	var retryAfter time.Duration
+	ok, retryAfter = s.isIPAddressRateAllowed(cli)
 	if !ok {
-		err = s.breakConnection(w)
-		if err != nil {
-			zlog.Error().Err(err).Msg("")
-			s.respondWithInternalServerError(w)
+		s.logDenial(cli, req.URL.String(), DenialReasonRateLimit)
+		s.respondWithTooManyRequests(w, retryAfter)
+		return
+	}
+
+	if isIPAddressDenied {
+		cli.UserName, ok = s.authenticateClient(req)
+		if !ok {
+			s.denyClient(w, req, cli, DenialReasonIPAddress)
+			return
+		}
+	} else if s.identifyClientByCertificate(req, cli) {
+		if !s.isCertificateSubjectAllowed(cli) {
+			s.denyClient(w, req, cli, DenialReasonCertificateSubject)
+			return
+		}
+	} else if s.isAuthenticationRequired() {
+		cli.UserName, ok = s.authenticateClient(req)
+		if !ok {
+			s.logDenial(cli, req.URL.String(), DenialReasonAuthentication)
+			s.respondWithProxyAuthenticationRequired(w)
 			return
 		}
+	}
+
+	ok, retryAfter = s.isUserRateAllowed(cli)
+	if !ok {
+		s.logDenial(cli, req.URL.String(), DenialReasonRateLimit)
+		s.respondWithTooManyRequests(w, retryAfter)
+		return
+	}
+
+	var dstHost string
+	var dstPort uint16
+	dstHost, dstPort, err = getDestination(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		zlog.Debug().Err(err).Msg("")
+		return
+	}
+
+	if !s.isPortAllowed(req.Method, dstPort) {
+		s.logDenial(cli, req.URL.String(), DenialReasonPort)
+		s.respondWithForbiddenPort(w)
+		return
+	}
+
+	var reason string
+	ok, reason = s.isDestinationAllowed(dstHost, dstPort)
+	if !ok {
+		s.logDenial(cli, req.URL.String(), reason)
+		s.respondWithForbiddenDestination(w)
 		return
 	}
 
+	if !s.acquireConnection(cli) {
+		s.logDenial(cli, req.URL.String(), DenialReasonConnectionLimit)
+		s.respondWithServiceUnavailable(w)
+		return
+	}
+	defer s.releaseConnection(cli)
+
+	pool := s.selectUpstreamPool(dstHost, dstPort, cli, req.URL.String())
+
 	switch req.Method {
 	case http.MethodConnect:
-		s.processHttpsRequest(w, req)
+		s.processHttpsRequest(w, req, cli, pool)
 	default:
-		s.processHttpRequest(w, req)
+		s.processHttpRequest(w, req, cli, pool)
 	}
 
-	zlog.Debug().Msgf("serve time of '%v' is %v ms",
-		req.URL.String(), time.Since(t1).Milliseconds())
+	zlog.Debug().Msgf("serve time of '%v' for client %v is %v ms",
+		req.URL.String(), cli, time.Since(t1).Milliseconds())
 }
 
 func (s *Server) breakConnection(w http.ResponseWriter) (err error) {
@@ -71,12 +157,22 @@ func (s *Server) breakConnection(w http.ResponseWriter) (err error) {
 	return nil
 }
 
-func (s *Server) processHttpsRequest(w http.ResponseWriter, req *http.Request) {
-	zlog.Debug().Msgf("request to '%s'", req.URL.String())
+func (s *Server) processHttpsRequest(w http.ResponseWriter, req *http.Request, cli *client, pool *upstream.Pool) {
+	zlog.Debug().Msgf("request to '%s' from client %v", req.URL.String(), cli)
 
 	// Establish a TCP connection with the target.
-	targetConn, err := s.dialWithTimeout(context.Background(), "tcp", req.URL.Host)
+	targetConn, err := s.dialTarget(context.Background(), pool, req.URL.Host)
 	if err != nil {
+		if errors.Is(err, errDestinationAddressIsForbidden) {
+			s.logDenial(cli, req.URL.String(), DenialReasonDestinationAddress)
+			s.respondWithForbiddenDestination(w)
+			return
+		}
+		if errors.Is(err, errUpstreamProxy) {
+			s.respondWithUpstreamError(w, err)
+			zlog.Error().Err(err).Msg("")
+			return
+		}
 		http.Error(w, "net.dial error", http.StatusInternalServerError)
 		zlog.Error().Err(err).Msg("")
 		return
@@ -90,6 +186,13 @@ func (s *Server) processHttpsRequest(w http.ResponseWriter, req *http.Request) {
 		}
 	}()
 
+	// HTTP/2 connections can not be hijacked, the tunnel is made in the
+	// request's stream.
+	if req.ProtoMajor >= 2 {
+		s.processHttp2ConnectRequest(w, req, targetConn)
+		return
+	}
+
 	// Hijack the client's connection.
 	hjk, ok := w.(http.Hijacker)
 	if !ok {
@@ -122,18 +225,16 @@ func (s *Server) processHttpsRequest(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	closer := make(chan bool, 2)
-	go s.copyData(targetConn, clientConn, &closer)
-	go s.copyData(clientConn, targetConn, &closer)
-	<-closer
-	<-closer
+	s.relayData(clientConn, targetConn)
 }
 
-func (s *Server) copyData(dst, src net.Conn, closer *chan bool) {
-	defer func() {
-		*closer <- true
-	}()
+// relayData copies data between the client and the target in both directions
+// until both sides have sent all their data.
+func (s *Server) relayData(clientConn, targetConn io.ReadWriter) {
+	relay.Pipe(clientConn, targetConn, s.copyData)
+}
 
+func (s *Server) copyData(dst io.Writer, src io.Reader) {
 	var err error
 	if s.parameters.MustUseSpeedLimiter {
 		// Limit the speed.
@@ -150,42 +251,81 @@ func (s *Server) copyData(dst, src net.Conn, closer *chan bool) {
 		}
 
 		_, err = io.Copy(dst, speedLimiter)
-		if err != nil {
+		if (err != nil) && !errors.Is(err, os.ErrDeadlineExceeded) {
 			zlog.Error().Err(err).Msg("")
 			return
 		}
 	} else {
 		// Do not limit the speed.
 		_, err = io.Copy(dst, src)
-		if err != nil {
+		if (err != nil) && !errors.Is(err, os.ErrDeadlineExceeded) {
 			zlog.Error().Err(err).Msg("")
 			return
 		}
 	}
 }
 
-func (s *Server) processHttpRequest(w http.ResponseWriter, req *http.Request) {
-	zlog.Debug().Msgf("http request to '%s'", req.URL.String())
+func (s *Server) processHttpRequest(w http.ResponseWriter, req *http.Request, cli *client, pool *upstream.Pool) {
+	zlog.Debug().Msgf("http request to '%s' from client %v", req.URL.String(), cli)
 
 	// Modify the original request.
 	s.modifyRequest(req)
 
-	// Make a request to the target.
+	// Select a member of the pool of upstream proxies.
+	var parent *upstream.Proxy
+	member, err := s.selectPoolMember(pool)
+	if err != nil {
+		s.respondWithBadGateway(w, err)
+		zlog.Error().Err(err).Msg("")
+		return
+	}
+	if member != nil {
+		parent = member.Proxy
+		member.Acquire()
+		defer member.Release()
+	}
+
+	// Make a request to the target. Redirections are returned to the
+	// client, so that their targets are checked as other requests.
 	client := &http.Client{
-		Transport: &http.Transport{
-			DialContext: s.dialWithTimeout,
+		Transport: s.newTargetTransport(parent),
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
 		},
 	}
 
 	var targetResponse *http.Response
-	var err error
 	targetResponse, err = client.Do(req)
 	if err != nil {
+		if errors.Is(err, errDestinationAddressIsForbidden) {
+			s.logDenial(cli, req.URL.String(), DenialReasonDestinationAddress)
+			s.respondWithForbiddenDestination(w)
+			return
+		}
+		// Any error of a request through the parent proxy is an error of
+		// the gateway.
+		if parent != nil {
+			if isPoolMemberFailure(err) {
+				s.ejectPoolMember(pool, member, err)
+			}
+			s.respondWithBadGateway(w, err)
+			zlog.Error().Err(err).Msg("")
+			return
+		}
 		http.Error(w, "client.do error", http.StatusInternalServerError)
 		zlog.Error().Err(err).Msg("")
 		return
 	}
 
+	if isParentAuthenticationRejected(parent, targetResponse) {
+		_ = targetResponse.Body.Close()
+		err = fmt.Errorf("%w: %w", errUpstreamProxy, errAuthenticationIsRejected)
+		s.respondWithBadGateway(w, err)
+		zlog.Error().Err(err).Msgf("%v", parent)
+		s.ejectPoolMember(pool, member, err)
+		return
+	}
+
 	// Apply processors to the data stream.
 	var stream io.Reader
 	var closers []io.Closer
@@ -257,6 +397,7 @@ func (s *Server) processHttpRequest(w http.ResponseWriter, req *http.Request) {
 
 func (s *Server) modifyRequest(req *http.Request) {
 	req.RequestURI = ""
+	req.Header.Del(header.HttpHeaderProxyAuthorization)
 	req.Header.Del(header.HttpHeaderKeepAlive)
 	req.Header.Del(header.HttpHeaderConnection)
 	req.Header.Add(header.HttpHeaderConnection, "close")
@@ -332,6 +473,9 @@ func (s *Server) dialWithTimeout(ctx context.Context, network, addr string) (net
 		Deadline:  time.Time{}, // Zero.
 		KeepAlive: time.Second * 15,
 	}
+	if s.parameters.MustUseSsrfProtection {
+		d.ControlContext = s.checkDialAddress
+	}
 	return d.DialContext(ctx, network, addr)
 }
 
@@ -0,0 +1,137 @@
+package server
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+
+	zlog "github.com/rs/zerolog/log"
+)
+
+// newHttpProtocols returns the protocols served to clients of a listener.
+// HTTP/2 is offered through ALPN on TLS listeners. Unencrypted HTTP/2 is
+// accepted only with prior knowledge, i.e. without the HTTP/1.1 upgrade.
+func newHttpProtocols(p *Parameters) (protocols *http.Protocols) {
+	protocols = new(http.Protocols)
+	protocols.SetHTTP1(true)
+
+	if (p.tlsConfig != nil) && p.MustUseHttp2 {
+		protocols.SetHTTP2(true)
+	}
+
+	if (p.tlsConfig == nil) && p.MustUseH2c {
+		protocols.SetUnencryptedHTTP2(true)
+	}
+
+	return protocols
+}
+
+// normaliseHttp2Request restores the target URL of a request received over
+// HTTP/2. In HTTP/2, the target of a proxied request is sent in the
+// ':authority' pseudo-header, which becomes the request's host, while the
+// URL contains only the path. Targets of secure requests are reached with
+// the CONNECT method, so the 'http' scheme is used. Requests addressed to
+// the proxy itself are left in the origin form.
+func (s *Server) normaliseHttp2Request(req *http.Request) {
+	if (req.ProtoMajor < 2) || (req.Method == http.MethodConnect) || (len(req.URL.Host) > 0) {
+		return
+	}
+
+	if s.isAddressedToProxy(req) {
+		return
+	}
+
+	req.URL.Scheme = "http"
+	req.URL.Host = req.Host
+}
+
+// isAddressedToProxy tells whether an HTTP/2 request is addressed to the
+// proxy itself, i.e. whether its authority is the local address of the
+// connection or the proxy address of the PAC file.
+func (s *Server) isAddressedToProxy(req *http.Request) bool {
+	if (len(s.parameters.PacProxyAddress) > 0) && strings.EqualFold(req.Host, s.parameters.PacProxyAddress) {
+		return true
+	}
+
+	localAddr, ok := req.Context().Value(http.LocalAddrContextKey).(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+
+	host, portStr, err := net.SplitHostPort(req.Host)
+	if err != nil {
+		return false
+	}
+
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return false
+	}
+
+	return (addr.Unmap() == localAddr.AddrPort().Addr().Unmap()) &&
+		(portStr == strconv.Itoa(localAddr.Port))
+}
+
+// streamConn is the client's side of a tunnel made in an HTTP/2 stream. Data
+// of the client is read from the request's body, data of the target is
+// written to the response, which is flushed after every write.
+type streamConn struct {
+	body io.Reader
+	w    http.ResponseWriter
+	rc   *http.ResponseController
+}
+
+func newStreamConn(w http.ResponseWriter, req *http.Request) *streamConn {
+	return &streamConn{
+		body: req.Body,
+		w:    w,
+		rc:   http.NewResponseController(w),
+	}
+}
+
+func (sc *streamConn) Read(b []byte) (n int, err error) {
+	return sc.body.Read(b)
+}
+
+func (sc *streamConn) Write(b []byte) (n int, err error) {
+	n, err = sc.w.Write(b)
+	if err != nil {
+		return n, err
+	}
+
+	return n, sc.rc.Flush()
+}
+
+func (sc *streamConn) SetReadDeadline(t time.Time) error {
+	return sc.rc.SetReadDeadline(t)
+}
+
+// processHttp2ConnectRequest accepts the CONNECT request received over
+// HTTP/2 and relays data between the request's stream and the target.
+func (s *Server) processHttp2ConnectRequest(w http.ResponseWriter, req *http.Request, targetConn net.Conn) {
+	clientConn := newStreamConn(w, req)
+
+	w.WriteHeader(http.StatusOK)
+	err := clientConn.rc.Flush()
+	if err != nil {
+		zlog.Error().Err(err).Msg("")
+		return
+	}
+
+	// A stream reset by the client does not end the target's data, so the
+	// reading of the target is interrupted.
+	stop := context.AfterFunc(req.Context(), func() {
+		_ = targetConn.SetReadDeadline(time.Now())
+	})
+	defer stop()
+
+	// A stream can not be half-closed while its request is read. When the
+	// target ends its data, the tunnel is closed. When the client ends its
+	// stream, the target's connection is half-closed, RFC 9113, 8.5.
+	s.relayData(clientConn, targetConn)
+}
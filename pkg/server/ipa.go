@@ -3,35 +3,109 @@ package server
 import (
 	"net"
 	"net/http"
+	"net/netip"
+	"strings"
 
-	ipa "github.com/vault-thirteen/auxie/IPA"
+	wm "github.com/vault-thirteen/Forward-Proxy/pkg/server/WorkMode"
 )
 
-func (s *Server) getClientIPAddress(req *http.Request) (ipaddr ipa.IPAddressV4, err error) {
-	var clientHost string
-	clientHost, _, err = net.SplitHostPort(req.RemoteAddr)
+const (
+	IPPrefixListSeparator = ","
+)
+
+// getClientIPAddress returns the IP address of the client. IPv4-mapped IPv6
+// addresses, which appear on dual-stack listeners, are converted into plain
+// IPv4 addresses, so that a single list entry matches the client regardless
+// of the listener's address family. IPv6 zones are dropped.
+//
+// When the direct peer is a trusted proxy and the usage of forwarding headers
+// is enabled, the address is taken from the 'Forwarded' or the
+// 'X-Forwarded-For' HTTP header.
+func (s *Server) getClientIPAddress(req *http.Request, ls *listener) (ipaddr netip.Addr, err error) {
+	ipaddr, err = ls.getClientIPAddressOfConnection(req.RemoteAddr)
 	if err != nil {
 		return ipaddr, err
 	}
 
-	clientIPAddr := net.ParseIP(clientHost)
-	if clientIPAddr == nil {
+	if s.parameters.MustUseForwardedHeaders && s.isTrustedProxy(ipaddr) {
+		forwardedAddr, ok := s.getForwardedClientAddress(req)
+		if ok {
+			return forwardedAddr, nil
+		}
+	}
+
+	return ipaddr, nil
+}
+
+// parseHostPortAddress parses the IP address of an 'address:port' pair.
+func parseHostPortAddress(hostPort string) (ipaddr netip.Addr, err error) {
+	var host string
+	host, _, err = net.SplitHostPort(hostPort)
+	if err != nil {
 		return ipaddr, err
 	}
 
-	ipaddr, err = ipa.NewFromString(clientIPAddr.String())
+	ipaddr, err = netip.ParseAddr(host)
 	if err != nil {
 		return ipaddr, err
 	}
 
-	return ipaddr, nil
+	return ipaddr.Unmap().WithZone(""), nil
 }
 
-func (s *Server) isIPAddressAllowed(ipaddr ipa.IPAddressV4) (ok bool) {
-	if s.parameters.workMode.IsPrivate() {
-		_, ok = s.parameters.workMode.WhiteList()[ipaddr]
-		return ok
+// parseIPPrefixList parses a comma-separated list of IP addresses and
+// networks.
+func parseIPPrefixList(s string) (list []netip.Prefix, err error) {
+	var prefix netip.Prefix
+	for _, part := range strings.Split(s, IPPrefixListSeparator) {
+		part = strings.TrimSpace(part)
+		if len(part) == 0 {
+			continue
+		}
+
+		prefix, err = wm.ParsePrefix(part)
+		if err != nil {
+			return nil, err
+		}
+
+		list = append(list, prefix)
+	}
+
+	return list, nil
+}
+
+func prefixListContains(list []netip.Prefix, addr netip.Addr) bool {
+	addr = addr.Unmap().WithZone("")
+
+	for _, prefix := range list {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// isIPAddressAllowed checks the client's IP address against the work mode of
+// the listener. The label of the matched list entry, if any, is returned for
+// logging.
+func isIPAddressAllowed(workMode *wm.WorkMode, ipaddr netip.Addr) (ok bool, label string) {
+	if !workMode.UsesList() {
+		return true, ""
+	}
+
+	entry, isListed := workMode.List().Lookup(ipaddr)
+	if isListed {
+		label = entry.Label
+	}
+
+	switch {
+	case workMode.IsPrivate():
+		return isListed, label
+
+	case workMode.IsRestricted():
+		return !isListed, label
 	}
 
-	return true
+	return true, label
 }
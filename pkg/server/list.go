@@ -0,0 +1,87 @@
+package server
+
+import (
+	"errors"
+	"log"
+	"time"
+
+	zlog "github.com/rs/zerolog/log"
+
+	wm "github.com/vault-thirteen/Forward-Proxy/pkg/server/WorkMode"
+)
+
+// ReloadList reloads the lists of IP addresses used by the work modes of the
+// server and of its listeners. If a new list can not be read, the old list is
+// kept and an error is returned; other lists are reloaded anyway.
+func (s *Server) ReloadList() (err error) {
+	var errs []error
+	var rerr error
+	for _, workMode := range s.workModesWithLists() {
+		rerr = workMode.Reload()
+		if rerr != nil {
+			errs = append(errs, rerr)
+			continue
+		}
+
+		log.Println("List of IP addresses has been reloaded: " + workMode.ListFilePath())
+	}
+
+	return errors.Join(errs...)
+}
+
+// watchListFile periodically checks the files of the lists of IP addresses
+// and reloads the lists when the files are changed.
+func (s *Server) watchListFile() {
+	defer s.subRoutines.Done()
+
+	ticker := time.NewTicker(time.Second * time.Duration(s.parameters.WorkModeListCheckPeriod))
+	defer ticker.Stop()
+
+	workModes := s.workModesWithLists()
+	var isReloaded bool
+	var err error
+	for {
+		select {
+		case <-s.quit:
+			log.Println("List file watcher has stopped.")
+			return
+
+		case <-ticker.C:
+			for _, workMode := range workModes {
+				isReloaded, err = workMode.ReloadIfChanged()
+				if err != nil {
+					zlog.Error().Err(err).Msg("list reload error, old list is kept")
+					continue
+				}
+				if isReloaded {
+					log.Println("List of IP addresses has been reloaded: " + workMode.ListFilePath())
+				}
+			}
+		}
+	}
+}
+
+// workModesWithLists returns the work modes which use lists of IP addresses.
+// Each work mode is returned once, even when it is shared by listeners.
+func (s *Server) workModesWithLists() (workModes []*wm.WorkMode) {
+	listeners := s.listeners
+	if s.socksListener != nil {
+		listeners = append(listeners[:len(listeners):len(listeners)], s.socksListener)
+	}
+
+	seen := make(map[*wm.WorkMode]bool)
+	for _, ls := range listeners {
+		if !ls.workMode.UsesList() || seen[ls.workMode] {
+			continue
+		}
+
+		seen[ls.workMode] = true
+		workModes = append(workModes, ls.workMode)
+	}
+
+	return workModes
+}
+
+func (s *Server) usesLists() bool {
+	return len(s.workModesWithLists()) > 0
+}
@@ -0,0 +1,326 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+
+	wm "github.com/vault-thirteen/Forward-Proxy/pkg/server/WorkMode"
+)
+
+const (
+	ErrListenAddressSyntax         = "listen address '%v': %w"
+	ErrUnsupportedListenNetwork    = "unsupported network: %v"
+	ErrListenAddressIsEmpty        = "address is empty"
+	ErrUnknownListenAddressOption  = "unknown option: %v"
+	ErrListWithoutWorkMode         = "list is set without work mode"
+	ErrSocketPathIsNotSocket       = "path exists and is not a socket: %v"
+	ErrListenAddressOptionRepeated = "option is repeated: %v"
+)
+
+// Networks of listeners.
+const (
+	ListenNetworkTcp  = "tcp"
+	ListenNetworkUnix = "unix"
+)
+
+// Options of listen addresses.
+const (
+	ListenAddressOptionMode = "mode"
+	ListenAddressOptionList = "list"
+)
+
+const (
+	ListenAddressSchemeSeparator = "://"
+)
+
+// unixSocketClientAddress is the IP address of clients connecting through a
+// Unix domain socket. Such clients are local, so they are treated as
+// loopback clients.
+var unixSocketClientAddress = netip.AddrFrom4([4]byte{127, 0, 0, 1})
+
+// listenAddress is a parsed listen address. Address is written in one of the
+// following forms:
+//   - 'host:port' or 'tcp://host:port' – a TCP address;
+//   - 'unix:///path/to/socket' – a path to a Unix domain socket;
+//   - 'systemd://name' – sockets with the name passed by systemd.
+//
+// The address may be followed by options of the listener, e.g.
+// 'tcp://10.0.0.1:8080?mode=private&list=vpn.txt'. The work mode and the list
+// of IP addresses set in options are used instead of the server's ones.
+type listenAddress struct {
+	Network string
+	Address string
+
+	// Own work mode of the listener. When it is nil, the server's work mode
+	// is used.
+	workMode *wm.WorkMode
+}
+
+// parseListenAddress parses a listen address with its options.
+func parseListenAddress(s string) (la *listenAddress, err error) {
+	la, err = parseListenAddressParts(strings.TrimSpace(s))
+	if err != nil {
+		return nil, fmt.Errorf(ErrListenAddressSyntax, s, err)
+	}
+
+	return la, nil
+}
+
+func parseListenAddressParts(s string) (la *listenAddress, err error) {
+	if !strings.Contains(s, ListenAddressSchemeSeparator) {
+		s = ListenNetworkTcp + ListenAddressSchemeSeparator + s
+	}
+
+	var u *url.URL
+	u, err = url.Parse(s)
+	if err != nil {
+		return nil, err
+	}
+
+	la = &listenAddress{
+		Network: strings.ToLower(u.Scheme),
+	}
+
+	switch la.Network {
+	case ListenNetworkTcp:
+		la.Address = u.Host
+		_, _, err = net.SplitHostPort(la.Address)
+		if err != nil {
+			return nil, err
+		}
+
+	case ListenNetworkUnix:
+		// Relative paths are written as 'unix://dir/file.sock'.
+		la.Address = u.Host + u.Path
+		if len(la.Address) == 0 {
+			return nil, errors.New(ErrListenAddressIsEmpty)
+		}
+
+	case ListenNetworkSystemd:
+		la.Address = u.Host + u.Path
+		if len(la.Address) == 0 {
+			return nil, errors.New(ErrListenAddressIsEmpty)
+		}
+
+	default:
+		return nil, fmt.Errorf(ErrUnsupportedListenNetwork, u.Scheme)
+	}
+
+	var workModeString, listFile string
+	for name, values := range u.Query() {
+		if len(values) > 1 {
+			return nil, fmt.Errorf(ErrListenAddressOptionRepeated, name)
+		}
+
+		switch strings.ToLower(name) {
+		case ListenAddressOptionMode:
+			workModeString = values[0]
+		case ListenAddressOptionList:
+			listFile = values[0]
+		default:
+			return nil, fmt.Errorf(ErrUnknownListenAddressOption, name)
+		}
+	}
+
+	if len(workModeString) > 0 {
+		la.workMode, err = wm.New(workModeString, listFile)
+		if err != nil {
+			return nil, err
+		}
+	} else if len(listFile) > 0 {
+		return nil, errors.New(ErrListWithoutWorkMode)
+	}
+
+	return la, nil
+}
+
+// String returns the address in the form used in the log.
+func (la *listenAddress) String() string {
+	if la.Network == ListenNetworkTcp {
+		return la.Address
+	}
+
+	return la.Network + ListenAddressSchemeSeparator + la.Address
+}
+
+// listen creates a listener at the address. A stale Unix domain socket left
+// by a previous run of the server is removed.
+func (la *listenAddress) listen() (l net.Listener, err error) {
+	if la.Network == ListenNetworkUnix {
+		err = removeStaleUnixSocket(la.Address)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return net.Listen(la.Network, la.Address)
+}
+
+func removeStaleUnixSocket(path string) (err error) {
+	var fi os.FileInfo
+	fi, err = os.Lstat(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+
+	if fi.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf(ErrSocketPathIsNotSocket, path)
+	}
+
+	return os.Remove(path)
+}
+
+// listener is a listener of the proxy server. Every HTTP listener has its own
+// HTTP server. Every listener may have its own work mode.
+type listener struct {
+	address *listenAddress
+
+	// Socket passed by systemd. It is nil when the listener creates its own
+	// socket.
+	inherited net.Listener
+
+	// Work mode which is used for clients of this listener. It is either the
+	// listener's own work mode or the server's one.
+	workMode *wm.WorkMode
+
+	// HTTP server of an HTTP listener. It is nil for a SOCKS listener.
+	httpServer *http.Server
+
+	// Network listener. It is created when the server starts. Network
+	// listeners of HTTP listeners are owned by their HTTP servers.
+	netListener net.Listener
+}
+
+// createListeners creates the listeners of the server. Addresses of the
+// systemd network use the sockets passed by systemd. Inherited sockets which
+// are not claimed by listen addresses are used by HTTP listeners with the
+// server's work mode. When no listen addresses are set and no sockets are
+// inherited, a single HTTP listener is created at the host and the port of
+// the server.
+func (s *Server) createListeners() (err error) {
+	var sockets []*inheritedSocket
+	sockets, err = inheritSockets()
+	if err != nil {
+		return err
+	}
+
+	var claimed []*inheritedSocket
+	if s.parameters.socksListenAddress != nil {
+		la := s.parameters.socksListenAddress
+		if la.Network != ListenNetworkSystemd {
+			s.socksListener = s.newListener(la, nil)
+		} else {
+			claimed, err = claimInheritedSockets(sockets, la.Address)
+			if err != nil {
+				return err
+			}
+			if len(claimed) > 1 {
+				return fmt.Errorf(ErrSeveralInheritedSockets, la.Address)
+			}
+
+			s.socksListener = s.newListener(la, claimed[0].listener)
+		}
+	}
+
+	for _, la := range s.parameters.listenAddresses {
+		if la.Network != ListenNetworkSystemd {
+			s.listeners = append(s.listeners, s.newHttpListener(la, nil))
+			continue
+		}
+
+		claimed, err = claimInheritedSockets(sockets, la.Address)
+		if err != nil {
+			return err
+		}
+
+		for _, socket := range claimed {
+			s.listeners = append(s.listeners, s.newHttpListener(la, socket.listener))
+		}
+	}
+
+	for _, socket := range sockets {
+		if socket.isClaimed {
+			continue
+		}
+
+		socket.isClaimed = true
+		la := &listenAddress{Network: ListenNetworkSystemd, Address: socket.name}
+		s.listeners = append(s.listeners, s.newHttpListener(la, socket.listener))
+	}
+
+	if len(s.listeners) == 0 {
+		la := &listenAddress{
+			Network: ListenNetworkTcp,
+			Address: net.JoinHostPort(s.parameters.Host, strconv.Itoa(int(s.parameters.Port))),
+		}
+		s.listeners = append(s.listeners, s.newHttpListener(la, nil))
+	}
+
+	return nil
+}
+
+// newListener creates a listener at the address. The inherited socket is set
+// for addresses of the systemd network.
+func (s *Server) newListener(la *listenAddress, inherited net.Listener) (ls *listener) {
+	ls = &listener{
+		address:   la,
+		inherited: inherited,
+		workMode:  la.workMode,
+	}
+
+	if ls.workMode == nil {
+		ls.workMode = s.parameters.workMode
+	}
+
+	return ls
+}
+
+// open creates the network listener.
+func (ls *listener) open() (err error) {
+	if ls.inherited != nil {
+		ls.netListener = ls.inherited
+		return nil
+	}
+
+	ls.netListener, err = ls.address.listen()
+	return err
+}
+
+// network returns the network of the listener's socket, i.e. 'tcp' or
+// 'unix'.
+func (ls *listener) network() string {
+	if ls.inherited != nil {
+		return ls.inherited.Addr().Network()
+	}
+
+	return ls.address.Network
+}
+
+// String returns the address of the listener in the form used in the log.
+func (ls *listener) String() string {
+	if ls.inherited != nil {
+		return fmt.Sprintf("%v (%s %v)", ls.address, ls.inherited.Addr().Network(), ls.inherited.Addr())
+	}
+
+	return ls.address.String()
+}
+
+// getClientIPAddressOfConnection returns the IP address of the client
+// connected to the listener.
+func (ls *listener) getClientIPAddressOfConnection(remoteAddr string) (ipaddr netip.Addr, err error) {
+	if ls.network() == ListenNetworkUnix {
+		return unixSocketClientAddress, nil
+	}
+
+	return parseHostPortAddress(remoteAddr)
+}
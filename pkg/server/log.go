@@ -1,12 +1,17 @@
 package server
 
 import (
+	"fmt"
 	"log"
 	"strings"
 
 	"github.com/rs/zerolog"
 )
 
+const (
+	ErrUnknownLogLevel = "unknown log level: %v"
+)
+
 const (
 	LogLevelDebug         = "debug"
 	LogLevelInformation   = "info"
@@ -50,6 +55,18 @@ func SetLogLevel(logLevelParameter string) {
 	zerolog.SetGlobalLevel(zerolog.Disabled)
 }
 
+func parseLogLevel(logLevelParameter string) (level zerolog.Level, err error) {
+	llpLC := strings.ToLower(logLevelParameter)
+
+	for _, lls := range logLevelSettings {
+		if llpLC == strings.ToLower(lls.TextParameter) {
+			return lls.ZerologValue, nil
+		}
+	}
+
+	return zerolog.Disabled, fmt.Errorf(ErrUnknownLogLevel, logLevelParameter)
+}
+
 func possibleLogLevelsHint() string {
 	var hint strings.Builder
 	for _, lls := range logLevelSettings {
@@ -0,0 +1,248 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"regexp"
+	"strings"
+	"text/template"
+
+	zlog "github.com/rs/zerolog/log"
+	"github.com/vault-thirteen/auxie/header"
+
+	dp "github.com/vault-thirteen/Forward-Proxy/pkg/server/DestinationPolicy"
+)
+
+const (
+	ErrPacBypassEntrySyntax   = "syntax error in PAC bypass list: %v"
+	ErrPacProxyAddressSyntax  = "syntax error in PAC proxy address: %v"
+	ErrPacTemplate            = "PAC template '%v': %w"
+	ErrPacProxyAddressIsUnset = "proxy address of PAC file is unknown"
+)
+
+const (
+	PacPath             = "/proxy.pac"
+	WpadPath            = "/wpad.dat"
+	ContentTypePac      = "application/x-ns-proxy-autoconfig"
+	PacBypassSeparator  = ","
+	PacBypassPlainHosts = "<local>"
+)
+
+// pacHostRegExp matches host names and IP addresses which may be written
+// into a PAC file without escaping.
+var pacHostRegExp = regexp.MustCompile(`^[A-Za-z0-9_.\-*]+$`)
+
+// pacProxyAddressRegExp matches proxy addresses which may be written into a
+// PAC file without escaping, including IPv6 addresses in square brackets.
+var pacProxyAddressRegExp = regexp.MustCompile(`^[A-Za-z0-9_.\-:\[\]]+$`)
+
+// PacTemplateDefault is a template of the PAC file which is used when no
+// template file is set.
+const PacTemplateDefault = `// Proxy auto-configuration file.
+function FindProxyForURL(url, host) {
+{{- range .BypassConditions}}
+	if ({{.}}) {
+		return "DIRECT";
+	}
+{{- end}}
+	return "{{.Proxy}}";
+}
+`
+
+// pacData is passed to the template of the PAC file.
+type pacData struct {
+	// Proxy is a result of the FindProxyForURL function for proxied
+	// requests, e.g. 'PROXY proxy.example.com:8080'.
+	Proxy string
+
+	// ProxyAddress is the address of the proxy, e.g.
+	// 'proxy.example.com:8080'.
+	ProxyAddress string
+
+	// BypassConditions are JavaScript conditions of the bypass list. A
+	// request goes directly when any of them is true.
+	BypassConditions []string
+}
+
+// newPacTemplate creates the template of the PAC file. The template is read
+// from the template file when it is set, otherwise the default template is
+// used.
+func newPacTemplate(p *Parameters) (tpl *template.Template, err error) {
+	if len(p.PacTemplateFile) == 0 {
+		return template.New("PAC").Parse(PacTemplateDefault)
+	}
+
+	var buf []byte
+	buf, err = os.ReadFile(p.PacTemplateFile)
+	if err != nil {
+		return nil, err
+	}
+
+	tpl, err = template.New("PAC").Parse(string(buf))
+	if err != nil {
+		return nil, fmt.Errorf(ErrPacTemplate, p.PacTemplateFile, err)
+	}
+
+	return tpl, nil
+}
+
+// parsePacBypassList converts the bypass list into JavaScript conditions of
+// the PAC file. The list is comma-separated, its entries are host names,
+// wildcard patterns like '*.example.com', IPv4 addresses and networks, and
+// the special '<local>' entry matching host names without dots.
+func parsePacBypassList(s string) (conditions []string, err error) {
+	for _, entry := range strings.Split(s, PacBypassSeparator) {
+		entry = strings.TrimSpace(entry)
+		if len(entry) == 0 {
+			continue
+		}
+
+		var condition string
+		condition, err = getPacBypassCondition(entry)
+		if err != nil {
+			return nil, err
+		}
+
+		conditions = append(conditions, condition)
+	}
+
+	return conditions, nil
+}
+
+func getPacBypassCondition(entry string) (condition string, err error) {
+	if entry == PacBypassPlainHosts {
+		return "isPlainHostName(host)", nil
+	}
+
+	// The isInNet function of PAC files supports only IPv4.
+	if strings.Contains(entry, "/") {
+		var prefix netip.Prefix
+		prefix, err = netip.ParsePrefix(entry)
+		if (err != nil) || !prefix.Addr().Is4() {
+			return "", fmt.Errorf(ErrPacBypassEntrySyntax, entry)
+		}
+
+		prefix = prefix.Masked()
+		mask := net.CIDRMask(prefix.Bits(), 32)
+		return fmt.Sprintf(`isInNet(host, "%s", "%s")`, prefix.Addr(), net.IP(mask)), nil
+	}
+
+	if !pacHostRegExp.MatchString(entry) {
+		return "", fmt.Errorf(ErrPacBypassEntrySyntax, entry)
+	}
+
+	entry = dp.NormaliseHostName(entry)
+	if strings.Contains(entry, "*") {
+		return fmt.Sprintf(`shExpMatch(host, "%s")`, entry), nil
+	}
+
+	return fmt.Sprintf(`host == "%s"`, entry), nil
+}
+
+// checkPacProxyAddress checks the proxy address set for the PAC file.
+func checkPacProxyAddress(address string) (err error) {
+	if len(address) == 0 {
+		return nil
+	}
+
+	_, _, err = net.SplitHostPort(address)
+	if (err != nil) || !pacProxyAddressRegExp.MatchString(address) {
+		return fmt.Errorf(ErrPacProxyAddressSyntax, address)
+	}
+
+	return nil
+}
+
+// isOriginFormRequest tells whether the request is addressed to the proxy
+// itself rather than to a target, e.g. 'GET /proxy.pac HTTP/1.1'.
+func isOriginFormRequest(req *http.Request) bool {
+	return (req.Method != http.MethodConnect) && (len(req.URL.Host) == 0)
+}
+
+// serveOriginFormRequest serves a request addressed to the proxy itself. Only
+// the PAC file is served, other requests are not forwarded anywhere.
+func (s *Server) serveOriginFormRequest(w http.ResponseWriter, req *http.Request) {
+	if s.parameters.MustServePac && ((req.URL.Path == PacPath) || (req.URL.Path == WpadPath)) {
+		s.servePac(w, req)
+		return
+	}
+
+	http.Error(w, "request must be addressed to a target", http.StatusBadRequest)
+}
+
+// servePac serves the PAC file. When the proxy address is not set, the
+// address which has been used by the client to reach the proxy is written
+// into the file.
+func (s *Server) servePac(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead:
+	default:
+		w.Header().Set(header.HttpHeaderAllow, http.MethodGet+", "+http.MethodHead)
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	address, err := s.getPacProxyAddress(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		zlog.Debug().Err(err).Msg("")
+		return
+	}
+
+	data := &pacData{
+		Proxy:            "PROXY " + address,
+		ProxyAddress:     address,
+		BypassConditions: s.parameters.pacBypassConditions,
+	}
+	if s.parameters.tlsConfig != nil {
+		data.Proxy = "HTTPS " + address
+	}
+
+	var buf bytes.Buffer
+	err = s.parameters.pacTemplate.Execute(&buf, data)
+	if err != nil {
+		s.respondWithInternalServerError(w)
+		zlog.Error().Err(err).Msg("")
+		return
+	}
+
+	w.Header().Set(header.HttpHeaderContentType, ContentTypePac)
+	_, err = w.Write(buf.Bytes())
+	if err != nil {
+		zlog.Debug().Err(err).Msg("")
+	}
+}
+
+// getPacProxyAddress returns the proxy address written into the PAC file.
+// The 'Host' header of the request is used when the address is not set. A
+// missing port is the default port of the scheme used to reach the proxy.
+func (s *Server) getPacProxyAddress(req *http.Request) (address string, err error) {
+	if len(s.parameters.PacProxyAddress) > 0 {
+		return s.parameters.PacProxyAddress, nil
+	}
+
+	address = req.Host
+	if len(address) == 0 {
+		return "", errors.New(ErrPacProxyAddressIsUnset)
+	}
+
+	if _, _, err = net.SplitHostPort(address); err != nil {
+		if req.TLS != nil {
+			address = net.JoinHostPort(strings.Trim(address, "[]"), "443")
+		} else {
+			address = net.JoinHostPort(strings.Trim(address, "[]"), "80")
+		}
+	}
+
+	err = checkPacProxyAddress(address)
+	if err != nil {
+		return "", err
+	}
+
+	return address, nil
+}
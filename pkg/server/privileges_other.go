@@ -0,0 +1,15 @@
+//go:build !unix
+
+package server
+
+import (
+	"errors"
+)
+
+const (
+	ErrPrivilegeDroppingIsNotSupported = "dropping of privileges is not supported by this operating system"
+)
+
+func dropPrivileges(_ string, _ string) (err error) {
+	return errors.New(ErrPrivilegeDroppingIsNotSupported)
+}
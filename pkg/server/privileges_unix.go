@@ -0,0 +1,90 @@
+//go:build unix
+
+package server
+
+import (
+	"os/user"
+	"strconv"
+	"syscall"
+)
+
+// dropPrivileges switches the process to the user and the group. When the
+// group is not set, the primary group of the user is used. Supplementary
+// groups are removed. All the threads of the process are switched.
+func dropPrivileges(userName string, groupName string) (err error) {
+	var uid, gid = -1, -1
+
+	if len(userName) > 0 {
+		var u *user.User
+		u, err = lookupUser(userName)
+		if err != nil {
+			return err
+		}
+
+		uid, err = strconv.Atoi(u.Uid)
+		if err != nil {
+			return err
+		}
+
+		gid, err = strconv.Atoi(u.Gid)
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(groupName) > 0 {
+		var g *user.Group
+		g, err = lookupGroup(groupName)
+		if err != nil {
+			return err
+		}
+
+		gid, err = strconv.Atoi(g.Gid)
+		if err != nil {
+			return err
+		}
+	}
+
+	// The group must be changed first, while the process still has the
+	// privilege to change it.
+	if gid >= 0 {
+		err = syscall.Setgroups([]int{gid})
+		if err != nil {
+			return err
+		}
+
+		err = syscall.Setgid(gid)
+		if err != nil {
+			return err
+		}
+	}
+
+	if uid >= 0 {
+		err = syscall.Setuid(uid)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// lookupUser finds a user by its name or by its numeric ID.
+func lookupUser(name string) (u *user.User, err error) {
+	_, err = strconv.Atoi(name)
+	if err == nil {
+		return user.LookupId(name)
+	}
+
+	return user.Lookup(name)
+}
+
+// lookupGroup finds a group by its name or by its numeric ID.
+func lookupGroup(name string) (g *user.Group, err error) {
+	_, err = strconv.Atoi(name)
+	if err == nil {
+		return user.LookupGroupId(name)
+	}
+
+	return user.LookupGroup(name)
+}
@@ -0,0 +1,80 @@
+package server
+
+import (
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	zlog "github.com/rs/zerolog/log"
+	"github.com/vault-thirteen/auxie/header"
+)
+
+const (
+	DenialReasonRateLimit = "request rate limit is exceeded"
+)
+
+const (
+	// RateLimiterCleanupPeriod is a period of removing idle buckets of the
+	// rate limiter.
+	RateLimiterCleanupPeriod = time.Minute
+
+	RateLimiterKeyPrefixIPAddress = "ip:"
+	RateLimiterKeyPrefixUser      = "user:"
+)
+
+// isIPAddressRateAllowed checks the rate of requests from the IP address of
+// the client. It is checked before the authentication, so that failed
+// attempts to authenticate are limited as well.
+func (s *Server) isIPAddressRateAllowed(cli *client) (ok bool, retryAfter time.Duration) {
+	if s.rateLimiter == nil {
+		return true, 0
+	}
+
+	return s.rateLimiter.Allow(RateLimiterKeyPrefixIPAddress + cli.IPAddress.String())
+}
+
+// isUserRateAllowed checks the rate of requests of an authenticated client
+// by its user name, so that a user connecting from several IP addresses is
+// limited as a whole. Clients which are not authenticated are limited by the
+// IP address only.
+func (s *Server) isUserRateAllowed(cli *client) (ok bool, retryAfter time.Duration) {
+	if (s.rateLimiter == nil) || (len(cli.UserName) == 0) {
+		return true, 0
+	}
+
+	return s.rateLimiter.Allow(RateLimiterKeyPrefixUser + cli.UserName)
+}
+
+func (s *Server) respondWithTooManyRequests(w http.ResponseWriter, retryAfter time.Duration) {
+	seconds := int(math.Ceil(retryAfter.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+
+	w.Header().Set(header.HttpHeaderRetryAfter, strconv.Itoa(seconds))
+	http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+}
+
+// cleanRateLimiter periodically removes idle buckets of the rate limiter.
+func (s *Server) cleanRateLimiter() {
+	defer s.subRoutines.Done()
+
+	ticker := time.NewTicker(RateLimiterCleanupPeriod)
+	defer ticker.Stop()
+
+	var removedCount int
+	for {
+		select {
+		case <-s.quit:
+			log.Println("Rate limiter cleaner has stopped.")
+			return
+
+		case <-ticker.C:
+			removedCount = s.rateLimiter.Cleanup()
+			zlog.Debug().Msgf("rate limiter: %d idle buckets removed, %d buckets in use",
+				removedCount, s.rateLimiter.Size())
+		}
+	}
+}
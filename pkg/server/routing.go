@@ -0,0 +1,48 @@
+package server
+
+import (
+	"fmt"
+
+	zlog "github.com/rs/zerolog/log"
+
+	upstream "github.com/vault-thirteen/Forward-Proxy/pkg/server/Upstream"
+)
+
+// selectUpstreamPool selects the way to the destination by the routing
+// table. When no route matches, the parent proxy set by the '-parent'
+// parameter is used if it is set. The returned pool is nil when the
+// destination must be connected directly. The chosen route is logged at the
+// debug level when the routing table is used.
+func (s *Server) selectUpstreamPool(host string, port uint16, cli *client, target string) (pool *upstream.Pool) {
+	table := s.parameters.routingTable
+	if table == nil {
+		return s.parameters.parentProxy
+	}
+
+	var way, reason string
+	route := table.Find(host, port, cli.IPAddress)
+	if route != nil {
+		pool = route.Pool
+		reason = fmt.Sprintf("by route '%v'", route)
+		switch {
+		case pool == nil:
+			way = "directly"
+		case pool.IsChecked():
+			way = fmt.Sprintf("via pool '%s'", pool.Name())
+		default:
+			way = fmt.Sprintf("via proxy '%s' (%v)", pool.Name(), pool)
+		}
+	} else {
+		pool = s.parameters.parentProxy
+		reason = "by default"
+		if pool == nil {
+			way = "directly"
+		} else {
+			way = fmt.Sprintf("via parent proxy %v", pool)
+		}
+	}
+
+	zlog.Debug().Msgf("request to '%s' from client %v goes %s %s", target, cli, way, reason)
+
+	return pool
+}
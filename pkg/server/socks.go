@@ -0,0 +1,399 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"os"
+	"slices"
+	"syscall"
+	"time"
+
+	zlog "github.com/rs/zerolog/log"
+
+	relay "github.com/vault-thirteen/Forward-Proxy/pkg/server/Relay"
+	socks "github.com/vault-thirteen/Forward-Proxy/pkg/server/SOCKS"
+	upstream "github.com/vault-thirteen/Forward-Proxy/pkg/server/Upstream"
+)
+
+const (
+	// SocksHandshakeTimeout is a time given to a SOCKS client for the
+	// negotiation of the authentication method, the authentication and the
+	// request.
+	SocksHandshakeTimeout = time.Second * 30
+
+	// SocksListenerTarget is shown in the log instead of a destination when a
+	// SOCKS client is denied before it has sent its request.
+	SocksListenerTarget = "SOCKS listener"
+)
+
+func (s *Server) startSocksServer() {
+	s.subRoutines.Add(1)
+	go s.acceptSocksConnections()
+}
+
+func (s *Server) acceptSocksConnections() {
+	defer s.subRoutines.Done()
+
+	var conn net.Conn
+	var err error
+	for {
+		conn, err = s.socksListener.netListener.Accept()
+		if err != nil {
+			if s.mustStop.Load() {
+				log.Println("SOCKS listener has stopped.")
+				return
+			}
+
+			s.httpErrors <- err
+			return
+		}
+
+		go s.serveSocksConnection(conn)
+	}
+}
+
+// serveSocksConnection serves a connection of a SOCKS client. Clients are
+// checked in the same way as clients of the HTTP proxy.
+func (s *Server) serveSocksConnection(conn net.Conn) {
+	var t1 = time.Now()
+
+	defer func() {
+		derr := conn.Close()
+		if (derr != nil) && !errors.Is(derr, net.ErrClosed) {
+			zlog.Error().Err(derr).Msg("")
+		}
+	}()
+
+	var err error
+	cli := new(client)
+	cli.IPAddress, err = s.socksListener.getClientIPAddressOfConnection(conn.RemoteAddr().String())
+	if err != nil {
+		zlog.Error().Err(err).Msg("")
+		return
+	}
+
+	var ok bool
+	ok, cli.Label = isIPAddressAllowed(s.socksListener.workMode, cli.IPAddress)
+	if !ok {
+		s.logDenial(cli, SocksListenerTarget, DenialReasonIPAddress)
+		return
+	}
+
+	err = conn.SetDeadline(time.Now().Add(SocksHandshakeTimeout))
+	if err != nil {
+		zlog.Error().Err(err).Msg("")
+		return
+	}
+
+	br := bufio.NewReader(conn)
+	var version byte
+	version, err = br.ReadByte()
+	if err != nil {
+		zlog.Debug().Err(err).Msg("")
+		return
+	}
+
+	switch version {
+	case socks.Version5:
+		s.serveSocks5Connection(relay.NewBufferedConn(conn, br), cli)
+	case socks.Version4:
+		s.serveSocks4Connection(relay.NewBufferedConn(conn, br), cli)
+	default:
+		zlog.Debug().Msgf("unsupported SOCKS version %d from client %v", version, cli)
+		return
+	}
+
+	zlog.Debug().Msgf("serve time of SOCKS connection of client %v is %v ms",
+		cli, time.Since(t1).Milliseconds())
+}
+
+// serveSocks5Connection serves a SOCKS5 client. The version byte must be
+// already read.
+func (s *Server) serveSocks5Connection(conn net.Conn, cli *client) {
+	methods, err := socks.ReadGreeting(conn)
+	if err != nil {
+		zlog.Debug().Err(err).Msg("")
+		return
+	}
+
+	ok, _ := s.isIPAddressRateAllowed(cli)
+	if !ok {
+		s.logDenial(cli, SocksListenerTarget, DenialReasonRateLimit)
+		_ = socks.WriteMethodSelection(conn, socks.MethodNoAcceptable)
+		return
+	}
+
+	cli.UserName, ok = s.authenticateSocksClient(conn, methods)
+	if !ok {
+		s.logDenial(cli, SocksListenerTarget, DenialReasonAuthentication)
+		return
+	}
+
+	var req *socks.Request
+	req, err = socks.ReadRequest(conn)
+	if err != nil {
+		var ate *socks.AddressTypeError
+		if errors.As(err, &ate) {
+			s.writeSocksReply(conn, socks.ReplyAddressTypeNotSupported, nil)
+		}
+		zlog.Debug().Err(err).Msg("")
+		return
+	}
+
+	switch req.Command {
+	case socks.CommandConnect,
+		socks.CommandUdpAssociate:
+	default:
+		zlog.Debug().Msgf("unsupported SOCKS command %d from client %v", req.Command, cli)
+		s.writeSocksReply(conn, socks.ReplyCommandNotSupported, nil)
+		return
+	}
+
+	target := req.Address()
+	ok, _ = s.isUserRateAllowed(cli)
+	if !ok {
+		s.logDenial(cli, target, DenialReasonRateLimit)
+		s.writeSocksReply(conn, socks.ReplyNotAllowed, nil)
+		return
+	}
+
+	// Destinations of UDP datagrams are checked separately for each
+	// destination. The address of the request is the client's address.
+	if req.Command == socks.CommandConnect {
+		var reason string
+		ok, reason = s.isSocksDestinationAllowed(req.Host, req.Port)
+		if !ok {
+			s.logDenial(cli, target, reason)
+			s.writeSocksReply(conn, socks.ReplyNotAllowed, nil)
+			return
+		}
+	}
+
+	if !s.acquireConnection(cli) {
+		s.logDenial(cli, target, DenialReasonConnectionLimit)
+		s.writeSocksReply(conn, socks.ReplyGeneralFailure, nil)
+		return
+	}
+	defer s.releaseConnection(cli)
+
+	switch req.Command {
+	case socks.CommandConnect:
+		pool := s.selectUpstreamPool(req.Host, req.Port, cli, target)
+		s.processSocksConnectRequest(conn, target, cli, pool, socks.WriteReply)
+	case socks.CommandUdpAssociate:
+		s.processSocksUdpAssociateRequest(conn, req, cli)
+	}
+}
+
+// serveSocks4Connection serves a SOCKS4 or a SOCKS4a client. The version byte
+// must be already read. SOCKS4 does not support passwords, so these clients
+// are denied when clients must authenticate.
+func (s *Server) serveSocks4Connection(conn net.Conn, cli *client) {
+	req, userID, err := socks.ReadRequest4(conn)
+	if err != nil {
+		zlog.Debug().Err(err).Msg("")
+		return
+	}
+
+	target := req.Address()
+	if s.isAuthenticationRequired() {
+		s.logDenial(cli, target, DenialReasonAuthentication)
+		s.writeSocks4Reply(conn, socks.Reply4Rejected)
+		return
+	}
+
+	if req.Command != socks.Command4Connect {
+		zlog.Debug().Msgf("unsupported SOCKS4 command %d from client %v", req.Command, cli)
+		s.writeSocks4Reply(conn, socks.Reply4Rejected)
+		return
+	}
+
+	if len(userID) > 0 {
+		zlog.Debug().Msgf("SOCKS4 request to '%s' from client %v has user ID '%s'", target, cli, userID)
+	}
+
+	ok, _ := s.isIPAddressRateAllowed(cli)
+	if !ok {
+		s.logDenial(cli, target, DenialReasonRateLimit)
+		s.writeSocks4Reply(conn, socks.Reply4Rejected)
+		return
+	}
+
+	var reason string
+	ok, reason = s.isSocksDestinationAllowed(req.Host, req.Port)
+	if !ok {
+		s.logDenial(cli, target, reason)
+		s.writeSocks4Reply(conn, socks.Reply4Rejected)
+		return
+	}
+
+	if !s.acquireConnection(cli) {
+		s.logDenial(cli, target, DenialReasonConnectionLimit)
+		s.writeSocks4Reply(conn, socks.Reply4Rejected)
+		return
+	}
+	defer s.releaseConnection(cli)
+
+	pool := s.selectUpstreamPool(req.Host, req.Port, cli, target)
+	s.processSocksConnectRequest(conn, target, cli, pool, writeSocks4ReplyForSocks5Code)
+}
+
+// isSocksDestinationAllowed checks the destination port against the set of
+// allowed SOCKS ports and the destination against the destination policy.
+func (s *Server) isSocksDestinationAllowed(host string, port uint16) (ok bool, reason string) {
+	if !s.parameters.allowedPortsSocks.Contains(port) {
+		return false, DenialReasonPort
+	}
+
+	return s.isDestinationAllowed(host, port)
+}
+
+// authenticateSocksClient selects an authentication method among the methods
+// offered by the client and authenticates the client. When the server has a
+// database of users, the username/password authentication is required,
+// otherwise no authentication is used.
+func (s *Server) authenticateSocksClient(conn net.Conn, methods []byte) (userName string, ok bool) {
+	var err error
+	if !s.isAuthenticationRequired() {
+		if !slices.Contains(methods, socks.MethodNoAuth) {
+			_ = socks.WriteMethodSelection(conn, socks.MethodNoAcceptable)
+			return "", false
+		}
+
+		err = socks.WriteMethodSelection(conn, socks.MethodNoAuth)
+		if err != nil {
+			zlog.Debug().Err(err).Msg("")
+			return "", false
+		}
+
+		return "", true
+	}
+
+	if !slices.Contains(methods, socks.MethodUserPassword) {
+		_ = socks.WriteMethodSelection(conn, socks.MethodNoAcceptable)
+		return "", false
+	}
+
+	err = socks.WriteMethodSelection(conn, socks.MethodUserPassword)
+	if err != nil {
+		zlog.Debug().Err(err).Msg("")
+		return "", false
+	}
+
+	var password string
+	userName, password, err = socks.ReadUserPassAuth(conn)
+	if err != nil {
+		zlog.Debug().Err(err).Msg("")
+		return "", false
+	}
+
+	ok = s.parameters.userDB.Authenticate(userName, password)
+	err = socks.WriteUserPassAuthStatus(conn, ok)
+	if err != nil {
+		zlog.Debug().Err(err).Msg("")
+		return "", false
+	}
+	if !ok {
+		return "", false
+	}
+
+	return userName, true
+}
+
+// socksReplyWriter writes a reply to a SOCKS request. Reply codes are the
+// codes of SOCKS5.
+type socksReplyWriter func(w io.Writer, reply byte, boundAddr net.Addr) (err error)
+
+// processSocksConnectRequest connects to the target, directly or through the
+// selected pool of upstream proxies, and relays data between the client and the target.
+func (s *Server) processSocksConnectRequest(clientConn net.Conn, target string, cli *client, pool *upstream.Pool, writeReply socksReplyWriter) {
+	zlog.Debug().Msgf("SOCKS request to '%s' from client %v", target, cli)
+
+	targetConn, err := s.dialTarget(context.Background(), pool, target)
+	if err != nil {
+		if errors.Is(err, errDestinationAddressIsForbidden) {
+			s.logDenial(cli, target, DenialReasonDestinationAddress)
+			_ = writeReply(clientConn, socks.ReplyNotAllowed, nil)
+			return
+		}
+		_ = writeReply(clientConn, getSocksReplyForDialError(err), nil)
+		zlog.Error().Err(err).Msg("")
+		return
+	}
+
+	defer func() {
+		derr := targetConn.Close()
+		if derr != nil {
+			zlog.Error().Err(derr).Msg("")
+			return
+		}
+	}()
+
+	err = writeReply(clientConn, socks.ReplySucceeded, targetConn.LocalAddr())
+	if err != nil {
+		zlog.Error().Err(err).Msg("")
+		return
+	}
+
+	// Remove the deadline of the handshake.
+	err = clientConn.SetDeadline(time.Time{})
+	if err != nil {
+		zlog.Error().Err(err).Msg("")
+		return
+	}
+
+	s.relayData(clientConn, targetConn)
+}
+
+func (s *Server) writeSocksReply(conn net.Conn, reply byte, boundAddr net.Addr) {
+	err := socks.WriteReply(conn, reply, boundAddr)
+	if err != nil {
+		zlog.Debug().Err(err).Msg("")
+	}
+}
+
+func (s *Server) writeSocks4Reply(conn net.Conn, reply byte) {
+	err := socks.WriteReply4(conn, reply)
+	if err != nil {
+		zlog.Debug().Err(err).Msg("")
+	}
+}
+
+// writeSocks4ReplyForSocks5Code writes a SOCKS4 reply for a reply code of
+// SOCKS5. SOCKS4 has a single code for all errors.
+func writeSocks4ReplyForSocks5Code(w io.Writer, reply byte, _ net.Addr) (err error) {
+	if reply == socks.ReplySucceeded {
+		return socks.WriteReply4(w, socks.Reply4Granted)
+	}
+
+	return socks.WriteReply4(w, socks.Reply4Rejected)
+}
+
+// getSocksReplyForDialError selects a SOCKS reply code describing the error
+// of connection to the target.
+func getSocksReplyForDialError(err error) (reply byte) {
+	var dnsErr *net.DNSError
+	var replyErr *socks.ReplyError
+	switch {
+	// A SOCKS5 parent proxy has its own reply, other errors of the parent
+	// proxy do not describe the target.
+	case errors.As(err, &replyErr):
+		return replyErr.Reply
+	case errors.Is(err, errUpstreamProxy):
+		return socks.ReplyGeneralFailure
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return socks.ReplyConnectionRefused
+	case errors.Is(err, syscall.ENETUNREACH):
+		return socks.ReplyNetworkUnreachable
+	case errors.Is(err, syscall.EHOSTUNREACH),
+		errors.Is(err, os.ErrDeadlineExceeded),
+		errors.As(err, &dnsErr):
+		return socks.ReplyHostUnreachable
+	default:
+		return socks.ReplyGeneralFailure
+	}
+}
@@ -0,0 +1,408 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/netip"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	zlog "github.com/rs/zerolog/log"
+
+	socks "github.com/vault-thirteen/Forward-Proxy/pkg/server/SOCKS"
+)
+
+const (
+	// SocksUdpDatagramSizeMax is the maximal size of a UDP datagram.
+	SocksUdpDatagramSizeMax = 65535
+
+	// SocksUdpTargetsMax is the maximal number of destinations of a single
+	// UDP association. Datagrams to other destinations are dropped.
+	SocksUdpTargetsMax = 256
+
+	// SocksUdpIdleCheckPeriodMax is the maximal period of checking UDP
+	// associations for idleness.
+	SocksUdpIdleCheckPeriodMax = time.Second * 5
+)
+
+// udpAssociation is a UDP relay created by the SOCKS5 'UDP ASSOCIATE'
+// command. Datagrams of the client are received on a relay socket and sent
+// to targets through separate sockets, one socket per destination. Each
+// destination is checked once, when its socket is created. Replies are
+// accepted only from the destinations used by the client.
+type udpAssociation struct {
+	server *Server
+	cli    *client
+
+	// Relay socket which receives datagrams of the client.
+	relayConn *net.UDPConn
+
+	// Address from which the client sends datagrams. When the client has not
+	// declared its port, the port of the first datagram is used.
+	clientAddrLock sync.RWMutex
+	clientAddr     netip.AddrPort
+
+	// Sockets connected to targets. A nil socket means a denied destination.
+	// No sockets are created after the association is closed.
+	targetsLock sync.Mutex
+	targets     map[string]net.Conn
+	isClosed    bool
+
+	lastActivity atomic.Int64
+	stopOnce     sync.Once
+	stop         chan struct{}
+
+	// Counters of datagrams and bytes of the payload. 'Sent' means data sent
+	// by the client to targets, 'received' means data received by the client
+	// from targets.
+	datagramsSent     atomic.Uint64
+	bytesSent         atomic.Uint64
+	datagramsReceived atomic.Uint64
+	bytesReceived     atomic.Uint64
+}
+
+// processSocksUdpAssociateRequest creates a UDP association and serves it
+// until the client closes the control connection, or the association is
+// idle for too long, or the server is stopped.
+func (s *Server) processSocksUdpAssociateRequest(controlConn net.Conn, req *socks.Request, cli *client) {
+	var t1 = time.Now()
+
+	ua := &udpAssociation{
+		server:  s,
+		cli:     cli,
+		targets: make(map[string]net.Conn),
+		stop:    make(chan struct{}),
+	}
+
+	// Only the IP address of the control connection may send datagrams.
+	ua.clientAddr = netip.AddrPortFrom(cli.IPAddress, 0)
+	declaredAddr, err := netip.ParseAddr(req.Host)
+	if (err == nil) && (declaredAddr.Unmap() == cli.IPAddress) {
+		ua.clientAddr = netip.AddrPortFrom(cli.IPAddress, req.Port)
+	}
+
+	// The relay socket is bound to the address where the client has
+	// connected to.
+	var localAddr netip.AddrPort
+	localAddr, err = netip.ParseAddrPort(controlConn.LocalAddr().String())
+	if err != nil {
+		s.writeSocksReply(controlConn, socks.ReplyGeneralFailure, nil)
+		zlog.Error().Err(err).Msg("")
+		return
+	}
+
+	ua.relayConn, err = net.ListenUDP("udp", net.UDPAddrFromAddrPort(netip.AddrPortFrom(localAddr.Addr(), 0)))
+	if err != nil {
+		s.writeSocksReply(controlConn, socks.ReplyGeneralFailure, nil)
+		zlog.Error().Err(err).Msg("")
+		return
+	}
+	defer ua.close()
+
+	err = socks.WriteReply(controlConn, socks.ReplySucceeded, ua.relayConn.LocalAddr())
+	if err != nil {
+		zlog.Error().Err(err).Msg("")
+		return
+	}
+
+	err = controlConn.SetDeadline(time.Time{})
+	if err != nil {
+		zlog.Error().Err(err).Msg("")
+		return
+	}
+
+	zlog.Debug().Msgf("SOCKS UDP association at '%v' for client %v is created",
+		ua.relayConn.LocalAddr(), cli)
+
+	s.socksUdpAssociations.Add(1)
+	defer s.socksUdpAssociations.Add(-1)
+
+	ua.touch()
+	go ua.watchControlConnection(controlConn)
+	go ua.relayClientDatagrams()
+	ua.waitForEnd()
+
+	s.socksUdpBytesSent.Add(ua.bytesSent.Load())
+	s.socksUdpBytesReceived.Add(ua.bytesReceived.Load())
+
+	zlog.Info().Msgf("SOCKS UDP association of client %v is closed after %v: "+
+		"%d datagrams (%d bytes) sent, %d datagrams (%d bytes) received",
+		cli, time.Since(t1).Round(time.Second),
+		ua.datagramsSent.Load(), ua.bytesSent.Load(),
+		ua.datagramsReceived.Load(), ua.bytesReceived.Load())
+}
+
+// waitForEnd waits until the association must be closed.
+func (ua *udpAssociation) waitForEnd() {
+	idleTimeout := ua.server.parameters.socksUdpIdleTimeout
+	checkPeriod := min(idleTimeout, SocksUdpIdleCheckPeriodMax)
+
+	ticker := time.NewTicker(checkPeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ua.stop:
+			return
+
+		case <-ua.server.quit:
+			return
+
+		case <-ticker.C:
+			if time.Since(time.Unix(0, ua.lastActivity.Load())) >= idleTimeout {
+				zlog.Debug().Msgf("SOCKS UDP association of client %v is idle", ua.cli)
+				return
+			}
+		}
+	}
+}
+
+// watchControlConnection ends the association when the client closes the
+// control connection. Data sent by the client over the control connection is
+// ignored.
+func (ua *udpAssociation) watchControlConnection(controlConn net.Conn) {
+	buf := make([]byte, 1)
+	for {
+		_, err := controlConn.Read(buf)
+		if err != nil {
+			ua.requestStop()
+			return
+		}
+	}
+}
+
+// relayClientDatagrams receives datagrams of the client and sends them to
+// targets.
+func (ua *udpAssociation) relayClientDatagrams() {
+	defer ua.requestStop()
+
+	buf := make([]byte, SocksUdpDatagramSizeMax)
+	for {
+		n, srcAddr, err := ua.relayConn.ReadFromUDPAddrPort(buf)
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				zlog.Error().Err(err).Msg("")
+			}
+			return
+		}
+
+		if !ua.acceptClientAddress(srcAddr) {
+			zlog.Debug().Msgf("UDP datagram from '%v' is dropped: client of the association is %v",
+				srcAddr, ua.cli)
+			continue
+		}
+
+		var host string
+		var port uint16
+		var data []byte
+		host, port, data, err = socks.ParseUdpDatagram(buf[:n])
+		if err != nil {
+			zlog.Debug().Err(err).Msg("")
+			continue
+		}
+
+		targetConn := ua.getTargetConnection(host, port)
+		if targetConn == nil {
+			continue
+		}
+
+		_, err = targetConn.Write(data)
+		if err != nil {
+			zlog.Debug().Err(err).Msg("")
+			continue
+		}
+
+		ua.touch()
+		ua.datagramsSent.Add(1)
+		ua.bytesSent.Add(uint64(len(data)))
+	}
+}
+
+// acceptClientAddress checks the source of a datagram received on the relay
+// socket. The first accepted datagram fixes the client's port when it was
+// not declared.
+func (ua *udpAssociation) acceptClientAddress(srcAddr netip.AddrPort) (ok bool) {
+	srcAddr = netip.AddrPortFrom(srcAddr.Addr().Unmap().WithZone(""), srcAddr.Port())
+
+	ua.clientAddrLock.Lock()
+	defer ua.clientAddrLock.Unlock()
+
+	if srcAddr.Addr() != ua.clientAddr.Addr() {
+		return false
+	}
+
+	if ua.clientAddr.Port() == 0 {
+		ua.clientAddr = srcAddr
+		return true
+	}
+
+	return srcAddr.Port() == ua.clientAddr.Port()
+}
+
+func (ua *udpAssociation) getClientAddress() netip.AddrPort {
+	ua.clientAddrLock.RLock()
+	defer ua.clientAddrLock.RUnlock()
+
+	return ua.clientAddr
+}
+
+// getTargetConnection returns a socket connected to the target. When the
+// target has not been used yet, it is checked and a new socket is created.
+// Nil is returned for denied targets and after the association is closed.
+func (ua *udpAssociation) getTargetConnection(host string, port uint16) (targetConn net.Conn) {
+	target := net.JoinHostPort(host, strconv.Itoa(int(port)))
+
+	ua.targetsLock.Lock()
+	defer ua.targetsLock.Unlock()
+
+	if ua.isClosed {
+		return nil
+	}
+
+	targetConn, isKnown := ua.targets[target]
+	if isKnown {
+		return targetConn
+	}
+
+	if len(ua.targets) >= SocksUdpTargetsMax {
+		zlog.Debug().Msgf("UDP datagram to '%s' from client %v is dropped: too many destinations",
+			target, ua.cli)
+		return nil
+	}
+
+	// Denied targets are remembered, so that denial is logged only once.
+	// Targets which have failed to be dialled are tried again by the next
+	// datagram.
+	s := ua.server
+	ok, reason := s.isSocksDestinationAllowed(host, port)
+	if !ok {
+		ua.targets[target] = nil
+		s.logDenial(ua.cli, "udp://"+target, reason)
+		return nil
+	}
+
+	targetConn, err := s.dialWithTimeout(context.Background(), "udp", target)
+	if err != nil {
+		if errors.Is(err, errDestinationAddressIsForbidden) {
+			ua.targets[target] = nil
+			s.logDenial(ua.cli, "udp://"+target, DenialReasonDestinationAddress)
+			return nil
+		}
+		zlog.Debug().Err(err).Msg("")
+		return nil
+	}
+
+	ua.targets[target] = targetConn
+	go ua.relayTargetDatagrams(target, targetConn)
+
+	return targetConn
+}
+
+// relayTargetDatagrams receives datagrams of the target and sends them to the
+// client. When the socket of the target breaks, it is closed and forgotten,
+// so that the next datagram to the target creates a new socket.
+func (ua *udpAssociation) relayTargetDatagrams(target string, targetConn net.Conn) {
+	srcAddr, err := netip.ParseAddrPort(targetConn.RemoteAddr().String())
+	if err != nil {
+		zlog.Error().Err(err).Msg("")
+		return
+	}
+	srcHost := srcAddr.Addr().Unmap().String()
+
+	buf := make([]byte, SocksUdpDatagramSizeMax)
+	var datagram []byte
+	for {
+		var n int
+		n, err = targetConn.Read(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			zlog.Debug().Err(err).Msg("")
+			if isIcmpError(err) {
+				continue
+			}
+			ua.forgetTarget(target, targetConn)
+			return
+		}
+
+		datagram, err = socks.AppendUdpDatagram(datagram[:0], srcHost, srcAddr.Port(), buf[:n])
+		if err != nil {
+			zlog.Error().Err(err).Msg("")
+			continue
+		}
+
+		_, err = ua.relayConn.WriteToUDPAddrPort(datagram, ua.getClientAddress())
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			zlog.Debug().Err(err).Msg("")
+			continue
+		}
+
+		ua.touch()
+		ua.datagramsReceived.Add(1)
+		ua.bytesReceived.Add(uint64(n))
+	}
+}
+
+// forgetTarget closes the socket of the target and removes it from the
+// association.
+func (ua *udpAssociation) forgetTarget(target string, targetConn net.Conn) {
+	ua.targetsLock.Lock()
+	if ua.targets[target] == targetConn {
+		delete(ua.targets, target)
+	}
+	ua.targetsLock.Unlock()
+
+	err := targetConn.Close()
+	if err != nil {
+		zlog.Debug().Err(err).Msg("")
+	}
+}
+
+// isIcmpError tells whether the error of a UDP socket is caused by an ICMP
+// message, e.g. 'connection refused'. Such errors do not break the socket.
+func isIcmpError(err error) bool {
+	return errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.EHOSTUNREACH) ||
+		errors.Is(err, syscall.ENETUNREACH)
+}
+
+func (ua *udpAssociation) touch() {
+	ua.lastActivity.Store(time.Now().UnixNano())
+}
+
+func (ua *udpAssociation) requestStop() {
+	ua.stopOnce.Do(func() {
+		close(ua.stop)
+	})
+}
+
+// close closes the relay socket and all sockets of targets.
+func (ua *udpAssociation) close() {
+	err := ua.relayConn.Close()
+	if err != nil {
+		zlog.Error().Err(err).Msg("")
+	}
+
+	ua.targetsLock.Lock()
+	defer ua.targetsLock.Unlock()
+
+	ua.isClosed = true
+	for _, targetConn := range ua.targets {
+		if targetConn == nil {
+			continue
+		}
+
+		err = targetConn.Close()
+		if err != nil {
+			zlog.Error().Err(err).Msg("")
+		}
+	}
+}
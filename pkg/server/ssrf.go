@@ -0,0 +1,72 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"syscall"
+)
+
+const (
+	ErrDestinationAddressIsForbidden = "destination address is forbidden"
+)
+
+var errDestinationAddressIsForbidden = errors.New(ErrDestinationAddressIsForbidden)
+
+// ssrfForbiddenNetworks are networks which may not be reached through the
+// proxy when the SSRF protection is enabled.
+var ssrfForbiddenNetworks = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),          // "This" network.
+	netip.MustParsePrefix("10.0.0.0/8"),         // Private, RFC 1918.
+	netip.MustParsePrefix("100.64.0.0/10"),      // Carrier-grade NAT, RFC 6598.
+	netip.MustParsePrefix("127.0.0.0/8"),        // Loopback.
+	netip.MustParsePrefix("169.254.0.0/16"),     // Link-local.
+	netip.MustParsePrefix("172.16.0.0/12"),      // Private, RFC 1918.
+	netip.MustParsePrefix("192.168.0.0/16"),     // Private, RFC 1918.
+	netip.MustParsePrefix("224.0.0.0/4"),        // Multicast.
+	netip.MustParsePrefix("255.255.255.255/32"), // Limited broadcast.
+	netip.MustParsePrefix("::/128"),             // Unspecified.
+	netip.MustParsePrefix("::1/128"),            // Loopback.
+	netip.MustParsePrefix("64:ff9b::/96"),       // NAT64, RFC 6052, may embed private IPv4 addresses.
+	netip.MustParsePrefix("2002::/16"),          // 6to4, RFC 3056, may embed private IPv4 addresses.
+	netip.MustParsePrefix("fc00::/7"),           // Unique local, RFC 4193.
+	netip.MustParsePrefix("fe80::/10"),          // Link-local.
+	netip.MustParsePrefix("ff00::/8"),           // Multicast.
+}
+
+// isAddressForbiddenBySsrfProtection checks whether the IP address belongs to
+// a forbidden network and is not listed in exceptions.
+func (s *Server) isAddressForbiddenBySsrfProtection(addr netip.Addr) bool {
+	addr = addr.Unmap().WithZone("")
+
+	if prefixListContains(s.parameters.ssrfExceptions, addr) {
+		return false
+	}
+
+	return prefixListContains(ssrfForbiddenNetworks, addr)
+}
+
+// checkDialAddress is called by the dialer after the host name has been
+// resolved and right before the connection is made. Checking the resolved
+// address at this moment also protects from DNS rebinding.
+func (s *Server) checkDialAddress(_ context.Context, network string, address string, _ syscall.RawConn) (err error) {
+	var host string
+	host, _, err = net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+
+	var addr netip.Addr
+	addr, err = netip.ParseAddr(host)
+	if err != nil {
+		return err
+	}
+
+	if s.isAddressForbiddenBySsrfProtection(addr) {
+		return fmt.Errorf("%w: %s %s", errDestinationAddressIsForbidden, network, address)
+	}
+
+	return nil
+}
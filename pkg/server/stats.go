@@ -0,0 +1,108 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+
+	zlog "github.com/rs/zerolog/log"
+	"github.com/vault-thirteen/auxie/header"
+)
+
+const (
+	DenialReasonConnectionLimit = "connection limit is reached"
+)
+
+const (
+	StatisticsPath  = "/stats"
+	ContentTypeJson = "application/json"
+)
+
+// Statistics is a snapshot of the server's current state for monitoring.
+type Statistics struct {
+	// Number of requests and tunnels being served at the moment.
+	Connections int `json:"connections"`
+
+	// Number of requests and tunnels being served at the moment per client
+	// IP address.
+	ConnectionsPerClient map[string]int `json:"connectionsPerClient"`
+
+	// Number of clients tracked by the request rate limiter.
+	RateLimitedClients int `json:"rateLimitedClients"`
+
+	// Number of active SOCKS UDP associations.
+	SocksUdpAssociations int64 `json:"socksUdpAssociations"`
+
+	// Payload bytes sent by SOCKS clients to targets and received from
+	// targets over UDP in closed associations.
+	SocksUdpBytesSent     uint64 `json:"socksUdpBytesSent"`
+	SocksUdpBytesReceived uint64 `json:"socksUdpBytesReceived"`
+
+	// States of members of upstream proxy pools by names of pools.
+	UpstreamPools map[string][]PoolMemberStatistics `json:"upstreamPools,omitempty"`
+}
+
+// PoolMemberStatistics is a state of a member of an upstream proxy pool.
+type PoolMemberStatistics struct {
+	Proxy       string `json:"proxy"`
+	IsEjected   bool   `json:"isEjected"`
+	Connections int64  `json:"connections"`
+}
+
+func (s *Server) GetStatistics() (stats *Statistics) {
+	stats = new(Statistics)
+	stats.Connections, stats.ConnectionsPerClient = s.connLimiter.Count()
+
+	if s.rateLimiter != nil {
+		stats.RateLimitedClients = s.rateLimiter.Size()
+	}
+
+	stats.SocksUdpAssociations = s.socksUdpAssociations.Load()
+	stats.SocksUdpBytesSent = s.socksUdpBytesSent.Load()
+	stats.SocksUdpBytesReceived = s.socksUdpBytesReceived.Load()
+
+	if s.usesPools() {
+		stats.UpstreamPools = make(map[string][]PoolMemberStatistics)
+		for _, pool := range s.parameters.routingTable.Pools() {
+			for _, m := range pool.Members() {
+				stats.UpstreamPools[pool.Name()] = append(stats.UpstreamPools[pool.Name()], PoolMemberStatistics{
+					Proxy:       m.Proxy.String(),
+					IsEjected:   m.IsEjected(),
+					Connections: m.Connections(),
+				})
+			}
+		}
+	}
+
+	return stats
+}
+
+// acquireConnection registers a request or a tunnel of the client. When the
+// global limit or the client's limit has been reached, false is returned.
+// Every successful call must be followed by a call of the releaseConnection
+// method.
+func (s *Server) acquireConnection(cli *client) (ok bool) {
+	return s.connLimiter.Acquire(cli.IPAddress.String())
+}
+
+func (s *Server) releaseConnection(cli *client) {
+	s.connLimiter.Release(cli.IPAddress.String())
+}
+
+func (s *Server) respondWithServiceUnavailable(w http.ResponseWriter) {
+	http.Error(w, "too many connections", http.StatusServiceUnavailable)
+}
+
+// statisticsHandler serves the statistics in JSON format on the monitoring
+// listener.
+func (s *Server) statisticsHandler(w http.ResponseWriter, req *http.Request) {
+	if req.URL.Path != StatisticsPath {
+		http.NotFound(w, req)
+		return
+	}
+
+	w.Header().Set(header.HttpHeaderContentType, ContentTypeJson)
+	err := json.NewEncoder(w).Encode(s.GetStatistics())
+	if err != nil {
+		zlog.Error().Err(err).Msg("")
+	}
+}
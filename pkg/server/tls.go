@@ -0,0 +1,117 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+)
+
+const (
+	ErrUnknownTlsClientAuth      = "unknown client certificate policy: %v"
+	ErrTlsCertificateKeyMismatch = "both a certificate and a key must be set for TLS"
+	ErrTlsIsNotEnabled           = "TLS must be enabled for client certificates"
+	ErrTlsClientCAIsNotSet       = "CA bundle file must be set for client certificates"
+	ErrTlsClientCAIsEmpty        = "no certificates found in CA bundle file: %v"
+)
+
+// TlsClientAuth is a policy of client certificates.
+const (
+	TlsClientAuthNone     = "none"
+	TlsClientAuthOptional = "optional"
+	TlsClientAuthRequired = "required"
+	TlsClientAuthDefault  = TlsClientAuthNone
+)
+
+const (
+	DenialReasonCertificateSubject = "certificate subject is not allowed"
+)
+
+func (p *Parameters) isTlsEnabled() bool {
+	return len(p.TlsCertFile) > 0
+}
+
+// newTlsConfig creates a TLS configuration of the listener.
+func newTlsConfig(p *Parameters) (cfg *tls.Config, err error) {
+	if (len(p.TlsCertFile) == 0) != (len(p.TlsKeyFile) == 0) {
+		return nil, errors.New(ErrTlsCertificateKeyMismatch)
+	}
+
+	var clientAuth tls.ClientAuthType
+	switch strings.ToLower(p.TlsClientAuth) {
+	case TlsClientAuthNone:
+		clientAuth = tls.NoClientCert
+	case TlsClientAuthOptional:
+		clientAuth = tls.VerifyClientCertIfGiven
+	case TlsClientAuthRequired:
+		clientAuth = tls.RequireAndVerifyClientCert
+	default:
+		return nil, fmt.Errorf(ErrUnknownTlsClientAuth, p.TlsClientAuth)
+	}
+
+	if !p.isTlsEnabled() {
+		if clientAuth != tls.NoClientCert {
+			return nil, errors.New(ErrTlsIsNotEnabled)
+		}
+		return nil, nil
+	}
+
+	p.tlsCertificate, err = newCertificateStore(p.TlsCertFile, p.TlsKeyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg = &tls.Config{
+		GetCertificate: p.tlsCertificate.GetCertificate,
+		ClientAuth:     clientAuth,
+		MinVersion:     tls.VersionTLS12,
+	}
+
+	if clientAuth == tls.NoClientCert {
+		return cfg, nil
+	}
+
+	if len(p.TlsClientCAFile) == 0 {
+		return nil, errors.New(ErrTlsClientCAIsNotSet)
+	}
+
+	var caBundle []byte
+	caBundle, err = os.ReadFile(p.TlsClientCAFile)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg.ClientCAs = x509.NewCertPool()
+	if !cfg.ClientCAs.AppendCertsFromPEM(caBundle) {
+		return nil, fmt.Errorf(ErrTlsClientCAIsEmpty, p.TlsClientCAFile)
+	}
+
+	return cfg, nil
+}
+
+// identifyClientByCertificate takes the identity of the client from its
+// verified TLS certificate. The certificate's common name is used as a user
+// name. When the client has no verified certificate, false is returned.
+func (s *Server) identifyClientByCertificate(req *http.Request, cli *client) (ok bool) {
+	if (req.TLS == nil) || (len(req.TLS.VerifiedChains) == 0) || (len(req.TLS.VerifiedChains[0]) == 0) {
+		return false
+	}
+
+	leaf := req.TLS.VerifiedChains[0][0]
+	cli.CertificateSubject = leaf.Subject.String()
+	cli.UserName = leaf.Subject.CommonName
+	return true
+}
+
+// isCertificateSubjectAllowed checks the common name of the client's
+// certificate against the list of allowed subjects.
+func (s *Server) isCertificateSubjectAllowed(cli *client) bool {
+	if s.parameters.tlsAllowedSubjects == nil {
+		return true
+	}
+
+	return s.parameters.tlsAllowedSubjects.Contains(cli.UserName)
+}
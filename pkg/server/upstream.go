@@ -0,0 +1,312 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	zlog "github.com/rs/zerolog/log"
+
+	relay "github.com/vault-thirteen/Forward-Proxy/pkg/server/Relay"
+	upstream "github.com/vault-thirteen/Forward-Proxy/pkg/server/Upstream"
+)
+
+const (
+	ErrUpstreamProxy            = "upstream proxy"
+	ErrAuthenticationIsRejected = "authentication is rejected"
+)
+
+const (
+	// ParentProxyName is a name of the parent proxy set by the '-parent'
+	// parameter.
+	ParentProxyName = "parent"
+)
+
+const (
+	// UpstreamHandshakeTimeout is a time given to the parent proxy for the
+	// negotiation of a tunnel.
+	UpstreamHandshakeTimeout = time.Second * 30
+
+	// UpstreamHealthCheckTimeout is a time given to a member of a pool of
+	// upstream proxies to complete a health check.
+	UpstreamHealthCheckTimeout = time.Second * 5
+)
+
+// errUpstreamProxy marks errors which have happened in the parent proxy or
+// while connecting to it.
+var errUpstreamProxy = errors.New(ErrUpstreamProxy)
+
+// errAuthenticationIsRejected is returned when an HTTP parent proxy does not
+// accept credentials of a plain HTTP request.
+var errAuthenticationIsRejected = errors.New(ErrAuthenticationIsRejected)
+
+// dialTarget connects to the target of a tunnel. When the pool of upstream
+// proxies is set, the connection is a tunnel through one of its members.
+func (s *Server) dialTarget(ctx context.Context, pool *upstream.Pool, address string) (conn net.Conn, err error) {
+	if pool == nil {
+		return s.dialWithTimeout(ctx, "tcp", address)
+	}
+
+	return s.dialViaPool(ctx, pool, address)
+}
+
+// dialViaPool requests a tunnel to the address from healthy members of the
+// pool in turn. A member which fails is ejected from the pool and the next
+// member is tried. When a member refuses to connect to the target, other
+// members are not tried.
+func (s *Server) dialViaPool(ctx context.Context, pool *upstream.Pool, address string) (conn net.Conn, err error) {
+	var members []*upstream.Member
+	members, err = pool.Candidates()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", errUpstreamProxy, err)
+	}
+
+	for i, m := range members {
+		conn, err = s.dialViaParent(ctx, m.Proxy, address)
+		if err == nil {
+			m.Acquire()
+			return &memberConn{Conn: conn, member: m}, nil
+		}
+
+		if upstream.IsRefusal(err) || (ctx.Err() != nil) {
+			return nil, err
+		}
+
+		s.ejectPoolMember(pool, m, err)
+		if i < len(members)-1 {
+			zlog.Warn().Err(err).Msgf("tunnel to '%s' via %v has failed, trying the next member of pool '%s'",
+				address, m.Proxy, pool.Name())
+		}
+	}
+
+	return nil, err
+}
+
+// dialViaParent connects to the parent proxy and requests a tunnel to the
+// address.
+func (s *Server) dialViaParent(ctx context.Context, parent *upstream.Proxy, address string) (tunnel net.Conn, err error) {
+	var conn net.Conn
+	conn, err = s.dialParent(ctx, "tcp", parent.Address())
+	if err != nil {
+		return nil, err
+	}
+
+	err = conn.SetDeadline(time.Now().Add(UpstreamHandshakeTimeout))
+	if err == nil {
+		tunnel, err = parent.Connect(conn, address)
+	}
+	if err == nil {
+		// Remove the deadline of the handshake.
+		err = conn.SetDeadline(time.Time{})
+	}
+	if err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("%w: %w", errUpstreamProxy, err)
+	}
+
+	return tunnel, nil
+}
+
+// dialParent connects to the parent proxy. The SSRF protection is not applied
+// to the parent proxy, which is usually located in a private network.
+func (s *Server) dialParent(ctx context.Context, network, address string) (conn net.Conn, err error) {
+	d := net.Dialer{
+		Timeout:   s.parameters.targetConnectionDialTimeout,
+		KeepAlive: time.Second * 15,
+	}
+
+	conn, err = d.DialContext(ctx, network, address)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", errUpstreamProxy, err)
+	}
+
+	return conn, nil
+}
+
+// newTargetTransport creates a transport for requests of the HTTP proxy.
+// Requests to an HTTP parent proxy are sent in the absolute form, requests
+// through a SOCKS5 parent proxy are sent through a tunnel.
+func (s *Server) newTargetTransport(parent *upstream.Proxy) (t *http.Transport) {
+	switch {
+	case parent == nil:
+		return &http.Transport{
+			DialContext: s.dialWithTimeout,
+		}
+	case parent.IsHttp():
+		return &http.Transport{
+			Proxy:       http.ProxyURL(parent.URL()),
+			DialContext: s.dialParent,
+		}
+	default:
+		return &http.Transport{
+			DialContext: func(ctx context.Context, _ string, address string) (net.Conn, error) {
+				return s.dialViaParent(ctx, parent, address)
+			},
+		}
+	}
+}
+
+// selectPoolMember selects a member of the pool for a request of the HTTP
+// proxy. The member is nil when the pool is nil.
+func (s *Server) selectPoolMember(pool *upstream.Pool) (m *upstream.Member, err error) {
+	if pool == nil {
+		return nil, nil
+	}
+
+	var members []*upstream.Member
+	members, err = pool.Candidates()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", errUpstreamProxy, err)
+	}
+
+	return members[0], nil
+}
+
+// isParentAuthenticationRejected tells whether the response comes from an
+// HTTP parent proxy which has not accepted credentials of this server. Such
+// a response is not passed to the client, whose credentials are not
+// concerned.
+func isParentAuthenticationRejected(parent *upstream.Proxy, resp *http.Response) bool {
+	return (parent != nil) && parent.IsHttp() &&
+		(resp.StatusCode == http.StatusProxyAuthRequired)
+}
+
+// isPoolMemberFailure tells whether the error shows that the upstream proxy
+// itself has failed.
+func isPoolMemberFailure(err error) bool {
+	return errors.Is(err, errUpstreamProxy) && !upstream.IsRefusal(err)
+}
+
+// ejectPoolMember ejects the failed member from a checked pool. A member
+// which has rejected the credentials is kept ejected until a handshake with
+// it succeeds.
+func (s *Server) ejectPoolMember(pool *upstream.Pool, m *upstream.Member, err error) {
+	isAuthRejection := upstream.IsAuthRejection(err) || errors.Is(err, errAuthenticationIsRejected)
+	if pool.Eject(m, isAuthRejection) {
+		zlog.Warn().Err(err).Msgf("member %v of pool '%s' is ejected", m.Proxy, pool.Name())
+	}
+}
+
+// checkPoolMembers probes members of the pools of upstream proxies
+// periodically. Failed members are ejected, recovered members are
+// reinstated.
+func (s *Server) checkPoolMembers() {
+	defer s.subRoutines.Done()
+
+	ticker := time.NewTicker(s.parameters.upstreamHealthCheckPeriod)
+	defer ticker.Stop()
+
+	pools := s.parameters.routingTable.Pools()
+	for {
+		select {
+		case <-s.quit:
+			log.Println("Upstream pool health checker has stopped.")
+			return
+
+		case <-ticker.C:
+			wg := new(sync.WaitGroup)
+			for _, pool := range pools {
+				for _, m := range pool.Members() {
+					wg.Add(1)
+					go func() {
+						defer wg.Done()
+						s.probePoolMember(pool, m)
+					}()
+				}
+			}
+			wg.Wait()
+		}
+	}
+}
+
+// probePoolMember checks the member of the pool. The member is connected and
+// a handshake is done with it: a tunnel to the probe target is requested
+// when the target is set, otherwise a SOCKS5 member negotiates the
+// authentication and an HTTP member is only connected.
+func (s *Server) probePoolMember(pool *upstream.Pool, m *upstream.Member) {
+	ctx, cf := context.WithTimeout(context.Background(), UpstreamHealthCheckTimeout)
+	defer cf()
+
+	conn, err := s.dialParent(ctx, "tcp", m.Proxy.Address())
+	if err != nil {
+		s.ejectPoolMember(pool, m, err)
+		return
+	}
+	defer func() {
+		derr := conn.Close()
+		if derr != nil {
+			zlog.Debug().Err(derr).Msg("")
+		}
+	}()
+
+	var isHandshakeDone bool
+	err = conn.SetDeadline(time.Now().Add(UpstreamHealthCheckTimeout))
+	if err == nil {
+		isHandshakeDone, err = m.Proxy.Probe(conn, s.parameters.UpstreamProbeTarget)
+	}
+	if upstream.IsRefusal(err) {
+		// The member has completed the handshake, only the probe target
+		// is not available.
+		zlog.Debug().Err(err).Msgf("probe target via %v", m.Proxy)
+		isHandshakeDone, err = true, nil
+	}
+	if err != nil {
+		s.ejectPoolMember(pool, m, fmt.Errorf("%w: %w", errUpstreamProxy, err))
+		return
+	}
+
+	if pool.Reinstate(m, isHandshakeDone) {
+		zlog.Info().Msgf("member %v of pool '%s' is reinstated", m.Proxy, pool.Name())
+	}
+}
+
+// usesPools tells whether the routing table has pools of upstream proxies.
+func (s *Server) usesPools() bool {
+	return (s.parameters.routingTable != nil) && (len(s.parameters.routingTable.Pools()) > 0)
+}
+
+// memberConn is a tunnel through a member of a pool. The tunnel is counted
+// by the member until it is closed.
+type memberConn struct {
+	net.Conn
+	member    *upstream.Member
+	closeOnce sync.Once
+}
+
+func (c *memberConn) Close() (err error) {
+	c.closeOnce.Do(c.member.Release)
+	return c.Conn.Close()
+}
+
+func (c *memberConn) CloseWrite() (err error) {
+	return relay.CloseWrite(c.Conn)
+}
+
+// respondWithUpstreamError describes an error of the parent proxy to the
+// client. A refusal of an HTTP parent proxy to connect to the target is
+// relayed with its status, other errors are errors of the gateway.
+func (s *Server) respondWithUpstreamError(w http.ResponseWriter, err error) {
+	var se *upstream.StatusError
+	if errors.As(err, &se) && upstream.IsRefusal(err) && (se.StatusCode >= 400) {
+		http.Error(w, err.Error(), se.StatusCode)
+		return
+	}
+
+	s.respondWithBadGateway(w, err)
+}
+
+// respondWithBadGateway describes an error of the parent proxy to the client.
+func (s *Server) respondWithBadGateway(w http.ResponseWriter, err error) {
+	var ue *url.Error
+	if errors.As(err, &ue) {
+		err = ue.Err
+	}
+
+	http.Error(w, err.Error(), http.StatusBadGateway)
+}